@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+)
+
+const (
+	formatGitHub = "github"
+	formatGitLab = "gitlab"
+
+	annotationMessage = "import block is not grouped correctly, run go-groups -w to fix"
+)
+
+// codeQualityIssue is a single entry of a GitLab code quality report.
+// see https://docs.gitlab.com/ee/user/project/merge_requests/code_quality.html for details.
+type codeQualityIssue struct {
+	Description string              `json:"description"`
+	CheckName   string              `json:"check_name"`
+	Fingerprint string              `json:"fingerprint"`
+	Severity    string              `json:"severity"`
+	Location    codeQualityLocation `json:"location"`
+}
+
+type codeQualityLocation struct {
+	Path  string           `json:"path"`
+	Lines codeQualityLines `json:"lines"`
+}
+
+type codeQualityLines struct {
+	Begin int `json:"begin"`
+	End   int `json:"end"`
+}
+
+// codeQualityIssues collects the issues of every processed file, since GitLab expects a single report.
+var codeQualityIssues = make([]codeQualityIssue, 0)
+
+func isValidFormat(format string) bool {
+	return format == "" || format == formatGitHub || format == formatGitLab
+}
+
+// misgroupedBlocks returns the import blocks of src which go-groups would rewrite with opts.
+func misgroupedBlocks(src []byte, opts *options) []importGroup {
+	_, _, groups := parseImportGroups(src, opts)
+	lines := strings.Split(string(src), "\n")
+
+	blocks := make([]importGroup, 0, len(groups))
+	for _, group := range groups {
+		buffer := bytes.Buffer{}
+		writeImportGroup(&buffer, regroupImportGroups(group, opts), opts)
+		original := strings.Join(lines[group.lineStart:group.lineEnd+1], "\n") + "\n"
+		if buffer.String() != original {
+			blocks = append(blocks, group)
+		}
+	}
+	return blocks
+}
+
+// annotate reports every misgrouped import block of src in the requested CI format.
+// GitHub workflow commands are written to out immediately, GitLab issues are collected
+// and written by writeCodeQualityReport once every file has been processed.
+// src is the gofmt output unless -f is given, line numbers refer to original, the file as it was read.
+func annotate(out io.Writer, filename string, original, src []byte, format string, opts *options) error {
+	originalDecls, decls := importDeclLines(original), importDeclLines(src)
+	for _, block := range misgroupedBlocks(src, opts) {
+		begin, end := block.lineStart+1, block.lineEnd+1
+		// gofmt keeps the import declarations in order, but may move their lines
+		if len(decls) == len(originalDecls) {
+			for i, decl := range decls {
+				if decl[0] == begin {
+					begin, end = originalDecls[i][0], originalDecls[i][1]
+					break
+				}
+			}
+		}
+		switch format {
+		case formatGitHub:
+			_, err := fmt.Fprintf(out, "::error file=%s,line=%d,endLine=%d,title=go-groups::%s\n",
+				escapeWorkflowProperty(filename), begin, end, annotationMessage)
+			if err != nil {
+				return err
+			}
+		case formatGitLab:
+			sum := sha256.Sum256([]byte(fmt.Sprintf("go-groups:%s:%d:%d", filename, begin, end)))
+			codeQualityIssues = append(codeQualityIssues, codeQualityIssue{
+				Description: annotationMessage,
+				CheckName:   "go-groups",
+				Fingerprint: hex.EncodeToString(sum[:]),
+				Severity:    "minor",
+				Location: codeQualityLocation{
+					Path:  filename,
+					Lines: codeQualityLines{Begin: begin, End: end},
+				},
+			})
+		}
+	}
+	return nil
+}
+
+// importDeclLines returns the first and last line of every grouped import declaration of src, or nil if
+// src cannot be parsed.
+func importDeclLines(src []byte) [][2]int {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	lines := make([][2]int, 0, 1)
+	for _, decl := range f.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.IMPORT && decl.Lparen.IsValid() {
+			lines = append(lines, [2]int{fset.Position(decl.Pos()).Line, fset.Position(decl.Rparen).Line})
+		}
+	}
+	return lines
+}
+
+// writeCodeQualityReport writes the collected GitLab code quality issues as a JSON array.
+func writeCodeQualityReport(out io.Writer) error {
+	data, err := json.MarshalIndent(codeQualityIssues, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// escapeWorkflowProperty escapes a value used as a property of a GitHub workflow command.
+func escapeWorkflowProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25",
+		"\r", "%0D",
+		"\n", "%0A",
+		":", "%3A",
+		",", "%2C",
+	).Replace(s)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotate_GitHub(t *testing.T) {
+	defer func(f string) { *format = f }(*format)
+	*format = formatGitHub
+
+	var buf bytes.Buffer
+	src := testdata(t, "external_groups_invalid.txt")
+	err := processFile("pkg/foo,bar.go", bytes.NewReader(src), &buf, false, flagOptions())
+	require.NoError(t, err)
+	require.Equal(t, "::error file=pkg/foo%2Cbar.go,line=3,endLine=17,title=go-groups::"+annotationMessage+"\n", buf.String())
+
+	buf.Reset()
+	src = testdata(t, "valid_imports.txt")
+	err = processFile("valid.go", bytes.NewReader(src), &buf, false, flagOptions())
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
+}
+
+func TestAnnotate_OriginalLines(t *testing.T) {
+	defer func(f string) { *format = f }(*format)
+	*format = formatGitHub
+
+	// gofmt removes the extra blank lines, which moves the import block up
+	src := strings.Join([]string{
+		"package foo",
+		"",
+		"",
+		"",
+		"import (",
+		"\t\"github.com/pkg/errors\"",
+		"\t\"fmt\"",
+		")",
+		"",
+		"var _ = fmt.Println",
+		"var _ = errors.New",
+		"",
+	}, "\n")
+	var buf bytes.Buffer
+	require.NoError(t, processFile("foo.go", strings.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, "::error file=foo.go,line=5,endLine=8,title=go-groups::"+annotationMessage+"\n", buf.String())
+}
+
+func TestAnnotate_GitLab(t *testing.T) {
+	defer func(f string) { *format = f }(*format)
+	defer func(issues []codeQualityIssue) { codeQualityIssues = issues }(codeQualityIssues)
+	*format = formatGitLab
+	codeQualityIssues = make([]codeQualityIssue, 0)
+
+	var buf bytes.Buffer
+	src := testdata(t, "external_groups_invalid.txt")
+	require.NoError(t, processFile("foo.go", bytes.NewReader(src), &buf, false, flagOptions()))
+	require.Empty(t, buf.String())
+
+	require.NoError(t, writeCodeQualityReport(&buf))
+	var issues []codeQualityIssue
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &issues))
+	require.Len(t, issues, 1)
+	require.Equal(t, "foo.go", issues[0].Location.Path)
+	require.Equal(t, codeQualityLines{Begin: 3, End: 17}, issues[0].Location.Lines)
+	require.Len(t, issues[0].Fingerprint, 64)
+}
+
+func TestMisgroupedBlocks(t *testing.T) {
+	src := strings.Join([]string{
+		"package foo",
+		"",
+		"import (",
+		"\t\"fmt\"",
+		"\t\"io\"",
+		")",
+		"",
+		"import (",
+		"\t\"os\"",
+		"\t\"errors\"",
+		")",
+		"",
+	}, "\n")
+	blocks := misgroupedBlocks([]byte(src), flagOptions())
+	require.Len(t, blocks, 1)
+	require.Equal(t, 7, blocks[0].lineStart)
+	require.Equal(t, 10, blocks[0].lineEnd)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	restoreCmd = "restore"
+
+	// manifestName is the name of the manifests listing the backups of the most recent run. A manifest is
+	// written to the -backup-dir, or to every directory where backups are kept next to the rewritten files.
+	manifestName = "go-groups-backup.jsonl"
+)
+
+// backupManifest lists the files rewritten by a run and where their original contents were kept. It is
+// stored as JSON lines, a header with the time of the run followed by one backupEntry per file. Entries
+// are appended as the files are rewritten, so that an interrupted run leaves a complete manifest.
+type backupManifest struct {
+	Created time.Time     `json:"created"`
+	Files   []backupEntry `json:"-"`
+}
+
+type backupEntry struct {
+	Path   string `json:"path"`
+	Backup string `json:"backup"`
+	// Sum is the SHA-256 of the rewritten contents, used to detect files modified after the run.
+	Sum string `json:"sum,omitempty"`
+	// Discarded cancels the previous entry of Path, whose rewrite failed.
+	Discarded bool `json:"discarded,omitempty"`
+}
+
+var (
+	// runManifests are the manifests written by the current run, which replaces the manifest of the
+	// previous run the first time it keeps a backup next to it.
+	manifestMu   sync.Mutex
+	runCreated   time.Time
+	runManifests = make(map[string]bool)
+)
+
+// keepBackups reports whether -backup-suffix or -backup-dir asked for backups to be kept.
+func keepBackups() bool {
+	return *backupSuffix != "" || *backupDir != ""
+}
+
+// manifestPath returns the path of the manifest listing the backup bakname.
+func manifestPath(bakname string) string {
+	if *backupDir != "" {
+		return filepath.Join(*backupDir, manifestName)
+	}
+	return filepath.Join(filepath.Dir(bakname), manifestName)
+}
+
+// backupPath returns the name of the backup of filename: filename with the -backup-suffix appended,
+// inside the -backup-dir if given. The backup directory mirrors the layout of the current directory,
+// files outside of it are kept under their absolute path.
+func backupPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	if *backupDir == "" {
+		return abs + *backupSuffix, nil
+	}
+	dir, err := filepath.Abs(*backupDir)
+	if err != nil {
+		return "", err
+	}
+	rel := strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	if wd, err := os.Getwd(); err == nil {
+		if r, err := filepath.Rel(wd, abs); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			rel = r
+		}
+	}
+	return filepath.Join(dir, rel) + *backupSuffix, nil
+}
+
+// isBackupDir reports whether path is the -backup-dir, which is skipped when walking directories.
+func isBackupDir(path string) bool {
+	if *backupDir == "" {
+		return false
+	}
+	dir, err := filepath.Abs(*backupDir)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == dir
+}
+
+// keepBackup saves src, the original contents of filename, before it is replaced with res, and records
+// the backup in the manifest. It does nothing unless -backup-suffix or -backup-dir are given.
+func keepBackup(filename string, src, res []byte) error {
+	if !keepBackups() {
+		return nil
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	bakname, err := backupPath(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(bakname), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(bakname, src, fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("cannot keep backup: %v", err)
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(res)
+	return appendManifest(manifestPath(bakname), backupEntry{Path: abs, Backup: bakname, Sum: hex.EncodeToString(sum[:])})
+}
+
+// discardBackup removes the backup of filename kept by keepBackup, after the rewrite failed.
+func discardBackup(filename string) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+	bakname, err := backupPath(filename)
+	if err != nil {
+		return
+	}
+	_ = os.Remove(bakname)
+	_ = appendManifest(manifestPath(bakname), backupEntry{Path: abs, Backup: bakname, Discarded: true})
+}
+
+// appendManifest appends entry to the manifest at path, which is replaced the first time the current run
+// writes to it.
+func appendManifest(path string, entry backupEntry) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	buffer := bytes.Buffer{}
+	enc := json.NewEncoder(&buffer)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !runManifests[path] {
+		if runCreated.IsZero() {
+			runCreated = time.Now().UTC()
+		}
+		if err := enc.Encode(backupManifest{Created: runCreated}); err != nil {
+			return err
+		}
+		flags |= os.O_TRUNC
+	}
+	if err := enc.Encode(entry); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write backup manifest: %v", err)
+	}
+	// a single write, so that an interrupted run does not leave a partial entry
+	_, err = f.Write(buffer.Bytes())
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write backup manifest: %v", err)
+	}
+	runManifests[path] = true
+	return nil
+}
+
+// writeManifest replaces the manifest at path with m.
+func writeManifest(path string, m *backupManifest) error {
+	buffer := bytes.Buffer{}
+	enc := json.NewEncoder(&buffer)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	for _, entry := range m.Files {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+func readManifest(path string) (*backupManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m backupManifest
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	for {
+		var entry backupEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+		}
+		if entry.Discarded {
+			for i := len(m.Files) - 1; i >= 0; i-- {
+				if m.Files[i].Path == entry.Path {
+					m.Files = append(m.Files[:i], m.Files[i+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		m.Files = append(m.Files, entry)
+	}
+	return &m, nil
+}
+
+// findManifests returns the manifests of the most recent run: the manifest of the -backup-dir if given, or
+// else the most recent manifests below paths.
+func findManifests(paths []string) ([]string, error) {
+	if *backupDir != "" {
+		return []string{filepath.Join(*backupDir, manifestName)}, nil
+	}
+	var latest time.Time
+	found := make([]string, 0)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() && path != root && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if fi.IsDir() || fi.Name() != manifestName {
+				return nil
+			}
+			m, err := readManifest(path)
+			if err != nil {
+				return err
+			}
+			switch {
+			case m.Created.After(latest):
+				latest, found = m.Created, []string{path}
+			case m.Created.Equal(latest):
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no %s found below %s", manifestName, strings.Join(paths, " "))
+	}
+	return found, nil
+}
+
+// restoreMain runs the restore command, which puts back every file rewritten by the most recent run
+// which kept backups.
+func restoreMain(args []string) int {
+	fs := flag.NewFlagSet(restoreCmd, flag.ExitOnError)
+	for _, name := range []string{"backup-dir", "in-place", "keep-mtime"} {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	force := fs.Bool("force", false, "restore files which were modified since they were rewritten")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] [path ...]\n", os.Args[0], restoreCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	manifests, err := findManifests(paths)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "cannot restore: "+err.Error())
+		return exitBadFlags
+	}
+	code := 0
+	for _, path := range manifests {
+		m, err := readManifest(path)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "cannot restore: "+err.Error())
+			return exitBadFlags
+		}
+		remaining, failed := restoreFiles(m, *force)
+		if len(remaining) == 0 {
+			err = os.Remove(path)
+		} else {
+			m.Files = remaining
+			err = writeManifest(path, m)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "cannot update manifest: "+err.Error())
+			return exitInternalError
+		}
+		if failed {
+			code = exitInternalError
+		}
+	}
+	return code
+}
+
+// restoreFiles restores the backups listed in m and removes them. Files modified since they were rewritten
+// are skipped unless force is set. It returns the entries which could not be restored.
+func restoreFiles(m *backupManifest, force bool) (remaining []backupEntry, failed bool) {
+	for _, entry := range m.Files {
+		if err := restoreFile(entry, force); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "cannot restore "+entry.Path+": "+err.Error())
+			remaining = append(remaining, entry)
+			failed = true
+			continue
+		}
+		_ = os.Remove(entry.Backup)
+		fmt.Println(entry.Path)
+	}
+	return remaining, failed
+}
+
+func restoreFile(entry backupEntry, force bool) error {
+	original, err := ioutil.ReadFile(entry.Backup)
+	if err != nil {
+		return err
+	}
+	current, err := ioutil.ReadFile(entry.Path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(entry.Path)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(current); hex.EncodeToString(sum[:]) != entry.Sum && !force {
+		return fmt.Errorf("file was modified since it was rewritten, use -force to restore it anyway")
+	}
+	return writeFile(entry.Path, current, original, takeSnapshot(fi, current))
+}
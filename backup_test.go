@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepBackup_Restore(t *testing.T) {
+	defer func(w bool, dir string) { *write, *backupDir = w, dir }(*write, *backupDir)
+	defer func(m map[string]bool) { runManifests = m }(runManifests)
+	runManifests = make(map[string]bool)
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	*write = true
+	*backupDir = filepath.Join(dir, "backups")
+
+	src := testdata(t, "external_groups_invalid.txt")
+	filename := filepath.Join(dir, "foo.go")
+	require.NoError(t, ioutil.WriteFile(filename, src, 0644))
+	require.NoError(t, processFile(filename, nil, &bytes.Buffer{}, false, flagOptions()))
+
+	rewritten, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, string(testdata(t, "external_groups.txt")), string(rewritten))
+
+	manifests, err := findManifests(nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(*backupDir, manifestName)}, manifests)
+	m, err := readManifest(manifests[0])
+	require.NoError(t, err)
+	require.Len(t, m.Files, 1)
+	require.Equal(t, filename, m.Files[0].Path)
+	backup, err := ioutil.ReadFile(m.Files[0].Backup)
+	require.NoError(t, err)
+	require.Equal(t, string(src), string(backup))
+	require.True(t, filepath.IsAbs(m.Files[0].Backup))
+
+	remaining, failed := restoreFiles(m, false)
+	require.False(t, failed)
+	require.Empty(t, remaining)
+	restored, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, string(src), string(restored))
+	_, err = os.Stat(m.Files[0].Backup)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestKeepBackup_Suffix(t *testing.T) {
+	defer func(w bool, suffix string) { *write, *backupSuffix = w, suffix }(*write, *backupSuffix)
+	defer func(m map[string]bool) { runManifests = m }(runManifests)
+	runManifests = make(map[string]bool)
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	*write = true
+	*backupSuffix = ".orig"
+
+	// the manifest of a previous run is replaced, the manifest of an older run elsewhere is ignored
+	older := filepath.Join(dir, "older", manifestName)
+	require.NoError(t, os.Mkdir(filepath.Dir(older), 0755))
+	require.NoError(t, writeManifest(older, &backupManifest{Created: time.Now().Add(-time.Hour)}))
+	previous := filepath.Join(dir, "a", manifestName)
+	require.NoError(t, os.Mkdir(filepath.Dir(previous), 0755))
+	require.NoError(t, writeManifest(previous, &backupManifest{Files: []backupEntry{{Path: "previous.go"}}}))
+
+	src := testdata(t, "external_groups_invalid.txt")
+	for _, name := range []string{"a/foo.go", "a/bar.go", "b/foo.go"} {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		require.NoError(t, ioutil.WriteFile(filename, src, 0644))
+		require.NoError(t, processFile(filename, nil, &bytes.Buffer{}, false, flagOptions()))
+	}
+	discardBackup(filepath.Join(dir, "a", "bar.go"))
+
+	manifests, err := findManifests([]string{dir})
+	require.NoError(t, err)
+	require.Equal(t, []string{previous, filepath.Join(dir, "b", manifestName)}, manifests)
+	m, err := readManifest(previous)
+	require.NoError(t, err)
+	require.Len(t, m.Files, 1)
+	require.Equal(t, filepath.Join(dir, "a", "foo.go"), m.Files[0].Path)
+	require.Equal(t, filepath.Join(dir, "a", "foo.go.orig"), m.Files[0].Backup)
+	_, err = os.Stat(filepath.Join(dir, "a", "bar.go.orig"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRestore_Modified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "foo.go")
+	bakname := filename + ".orig"
+	require.NoError(t, ioutil.WriteFile(filename, []byte("edited"), 0644))
+	require.NoError(t, ioutil.WriteFile(bakname, []byte("original"), 0644))
+	m := &backupManifest{Files: []backupEntry{{Path: filename, Backup: bakname, Sum: "rewritten"}}}
+
+	remaining, failed := restoreFiles(m, false)
+	require.True(t, failed)
+	require.Equal(t, m.Files, remaining)
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "edited", string(content))
+
+	remaining, failed = restoreFiles(m, true)
+	require.False(t, failed)
+	require.Empty(t, remaining)
+	content, err = ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "original", string(content))
+}
+
+func TestBackupPath(t *testing.T) {
+	defer func(suffix, dir string) { *backupSuffix, *backupDir = suffix, dir }(*backupSuffix, *backupDir)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	*backupSuffix = ".orig"
+	name, err := backupPath(filepath.Join("pkg", "foo.go"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(wd, "pkg", "foo.go.orig"), name)
+
+	*backupDir = "backups"
+	name, err = backupPath(filepath.Join("pkg", "foo.go"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(wd, "backups", "pkg", "foo.go.orig"), name)
+	require.True(t, isBackupDir(filepath.Join(".", "backups")))
+}
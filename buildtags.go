@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/build"
+	"go/build/constraint"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// skippedBuild is the reason reported for the files excluded by build constraints.
+const skippedBuild = "build constraints"
+
+// filtersBuild reports whether -tags, -goos or -goarch restrict the files processed to a build configuration.
+func filtersBuild(opts *options) bool {
+	return opts.buildTags != "" || opts.goos != "" || opts.goarch != ""
+}
+
+// buildContext returns the build configuration selected by -tags, -goos and -goarch. cgo files are always
+// part of it, since whether cgo is enabled does not matter to the grouping of imports.
+func buildContext(src []byte, opts *options) build.Context {
+	ctxt := build.Default
+	if opts.goos != "" {
+		ctxt.GOOS = opts.goos
+	}
+	if opts.goarch != "" {
+		ctxt.GOARCH = opts.goarch
+	}
+	ctxt.BuildTags = splitList(opts.buildTags)
+	ctxt.CgoEnabled = true
+	// the file may not exist, as with -stdin-filename
+	ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(src)), nil
+	}
+	return ctxt
+}
+
+// isBuildExcluded reports whether filename, whose contents are src, is excluded by its file name suffixes
+// or build constraints from the configuration selected by -tags, -goos and -goarch, or with -skip-ignored
+// is only built with the ignore tag. Files which are not named like Go files, such as standard input, are
+// never excluded.
+func isBuildExcluded(filename string, src []byte, opts *options) bool {
+	if !strings.HasSuffix(filename, ".go") {
+		return false
+	}
+	ignored := opts.skipIgnored && hasIgnoreConstraint(src)
+	if !filtersBuild(opts) && !ignored {
+		return false
+	}
+	ctxt := buildContext(src, opts)
+	dir, base := filepath.Dir(filename), filepath.Base(filename)
+	if filtersBuild(opts) {
+		if match, err := ctxt.MatchFile(dir, base); err == nil && !match {
+			return true
+		}
+	}
+	return ignored && requiresIgnoreTag(ctxt, dir, base)
+}
+
+// requiresIgnoreTag reports whether the file base in dir is built in ctxt with the ignore tag but not
+// without it, as are the scripts run with go run, such as //go:build ignore, but not a file constrained by
+// //go:build !linux || ignore when building for another OS.
+func requiresIgnoreTag(ctxt build.Context, dir, base string) bool {
+	if match, err := ctxt.MatchFile(dir, base); err != nil || match {
+		return false
+	}
+	ctxt.BuildTags = append(ctxt.BuildTags[:len(ctxt.BuildTags):len(ctxt.BuildTags)], "ignore")
+	match, err := ctxt.MatchFile(dir, base)
+	return err == nil && match
+}
+
+// hasIgnoreConstraint reports whether a build constraint before the package clause of src mentions the
+// ignore tag.
+func hasIgnoreConstraint(src []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case constraint.IsGoBuild(line) || constraint.IsPlusBuild(line):
+			if expr, err := constraint.Parse(line); err == nil && mentionsTag(expr, "ignore") {
+				return true
+			}
+		case line == "" || strings.HasPrefix(line, "//"):
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// mentionsTag reports whether the build constraint expr refers to tag.
+func mentionsTag(expr constraint.Expr, tag string) bool {
+	switch expr := expr.(type) {
+	case *constraint.TagExpr:
+		return expr.Tag == tag
+	case *constraint.NotExpr:
+		return mentionsTag(expr.X, tag)
+	case *constraint.AndExpr:
+		return mentionsTag(expr.X, tag) || mentionsTag(expr.Y, tag)
+	case *constraint.OrExpr:
+		return mentionsTag(expr.X, tag) || mentionsTag(expr.Y, tag)
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsBuildExcluded(t *testing.T) {
+	defer func(tags, targetOS, targetArch string, ignored bool) {
+		*buildTags, *goos, *goarch, *skipIgnored = tags, targetOS, targetArch, ignored
+	}(*buildTags, *goos, *goarch, *skipIgnored)
+
+	src := []byte("package foo\n")
+	ignored := []byte("//go:build ignore\n// +build ignore\n\npackage main\n")
+	tagged := []byte("//go:build integration\n// +build integration\n\npackage foo\n")
+
+	// nothing is excluded unless requested
+	require.False(t, isBuildExcluded("foo_windows.go", src, flagOptions()))
+	require.False(t, isBuildExcluded("gen.go", ignored, flagOptions()))
+
+	*skipIgnored = true
+	require.True(t, isBuildExcluded("gen.go", ignored, flagOptions()))
+	require.False(t, isBuildExcluded("gen.go", []byte("//go:build !ignore\n\npackage main\n"), flagOptions()))
+	require.False(t, isBuildExcluded("foo.go", tagged, flagOptions()))
+
+	*goos, *goarch = "linux", "amd64"
+	require.False(t, isBuildExcluded("foo_linux.go", src, flagOptions()))
+	require.True(t, isBuildExcluded("foo_windows.go", src, flagOptions()))
+	require.True(t, isBuildExcluded("foo_linux_arm64.go", src, flagOptions()))
+	require.True(t, isBuildExcluded("foo.go", tagged, flagOptions()))
+	require.False(t, isBuildExcluded("<standard input>", tagged, flagOptions()))
+
+	*buildTags = "integration"
+	require.False(t, isBuildExcluded("foo.go", tagged, flagOptions()))
+	require.True(t, isBuildExcluded("pkg/foo_windows.go", tagged, flagOptions()))
+}
+
+func TestRequiresIgnoreTag(t *testing.T) {
+	src := []byte("//go:build !linux || ignore\n\npackage main\n")
+	require.True(t, hasIgnoreConstraint(src))
+	require.False(t, hasIgnoreConstraint([]byte("//go:build ignored\n\npackage main\n")))
+	require.False(t, hasIgnoreConstraint([]byte("package main\n\n//go:build ignore\n")))
+
+	// the file is built without the ignore tag except on linux
+	opts := flagOptions()
+	opts.goos = "linux"
+	require.True(t, requiresIgnoreTag(buildContext(src, opts), ".", "gen.go"))
+	opts.goos = "darwin"
+	require.False(t, requiresIgnoreTag(buildContext(src, opts), ".", "gen.go"))
+	opts.skipIgnored = true
+	require.False(t, isBuildExcluded("gen.go", src, opts))
+
+	// the +build lines of a file are combined
+	src = []byte("// +build ignore windows\n// +build !windows\n\npackage main\n")
+	require.True(t, hasIgnoreConstraint(src))
+	require.True(t, requiresIgnoreTag(buildContext(src, opts), ".", "gen.go"))
+	opts.goos = "windows"
+	require.False(t, requiresIgnoreTag(buildContext(src, opts), ".", "gen.go"))
+}
+
+func TestProcessFile_BuildExcluded(t *testing.T) {
+	defer func(targetOS string) { *goos = targetOS }(*goos)
+	*goos = "linux"
+
+	var buf bytes.Buffer
+	src := testdata(t, "external_groups_invalid.txt")
+	require.NoError(t, processFile("foo_windows.go", bytes.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, string(src), buf.String())
+
+	resp := formatSource(formatRequest{Filename: "foo_windows.go", Content: string(src)})
+	require.Equal(t, formatResponse{Filename: "foo_windows.go", Content: string(src), Skipped: skippedBuild}, resp)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configName is the name of the project configuration which applies to a file, found in the directory of the
+// file or its closest parent.
+const configName = ".go-groups"
+
+// options are the settings of the flags a configuration may set, those which select how imports are grouped
+// and which files are processed, to process a file with. Flags selecting the output, such as -w or -l, are
+// only taken from the command line.
+type options struct {
+	local        string
+	blankGroup   bool
+	blankComment string
+	dotGroup     bool
+	dotComment   string
+
+	headers        bool
+	stdHeader      string
+	externalHeader string
+	localHeader    string
+	dropComments   bool
+
+	generated        generatedPolicy
+	generatedMarkers string
+	generatedFiles   string
+
+	buildTags   string
+	goos        string
+	goarch      string
+	skipIgnored bool
+}
+
+// flagOptions returns the options given by the flags, ignoring any configuration.
+func flagOptions() *options {
+	return &options{
+		local:        *local,
+		blankGroup:   *blankGroup,
+		blankComment: *blankComment,
+		dotGroup:     *dotGroup,
+		dotComment:   *dotComment,
+
+		headers:        *headers,
+		stdHeader:      *stdHeader,
+		externalHeader: *externalHeader,
+		localHeader:    *localHeader,
+		dropComments:   *dropComments,
+
+		generated:        generatedMode(),
+		generatedMarkers: *generatedMarkers,
+		generatedFiles:   *generatedFiles,
+
+		buildTags:   *buildTags,
+		goos:        *goos,
+		goarch:      *goarch,
+		skipIgnored: *skipIgnored,
+	}
+}
+
+// flagSet returns the flags a configuration may set, which set the fields of o.
+func (o *options) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(configName, flag.ContinueOnError)
+	fs.StringVar(&o.local, "local", o.local, "")
+	fs.BoolVar(&o.blankGroup, "blank-group", o.blankGroup, "")
+	fs.StringVar(&o.blankComment, "blank-comment", o.blankComment, "")
+	fs.BoolVar(&o.dotGroup, "dot-group", o.dotGroup, "")
+	fs.StringVar(&o.dotComment, "dot-comment", o.dotComment, "")
+
+	fs.BoolVar(&o.headers, "headers", o.headers, "")
+	fs.StringVar(&o.stdHeader, "std-header", o.stdHeader, "")
+	fs.StringVar(&o.externalHeader, "external-header", o.externalHeader, "")
+	fs.StringVar(&o.localHeader, "local-header", o.localHeader, "")
+	fs.BoolVar(&o.dropComments, "drop-comments", o.dropComments, "")
+
+	fs.Var((*policyValue)(&o.generated), "generated", "")
+	fs.StringVar(&o.generatedMarkers, "generated-markers", o.generatedMarkers, "")
+	fs.StringVar(&o.generatedFiles, "generated-files", o.generatedFiles, "")
+
+	fs.StringVar(&o.buildTags, "tags", o.buildTags, "")
+	fs.StringVar(&o.goos, "goos", o.goos, "")
+	fs.StringVar(&o.goarch, "goarch", o.goarch, "")
+	fs.BoolVar(&o.skipIgnored, "skip-ignored", o.skipIgnored, "")
+	return fs
+}
+
+// apply sets the options which were not given on the command line to the values of the entries of the
+// configuration filename.
+func (o *options) apply(filename string, entries []configEntry) error {
+	fs := o.flagSet()
+	for _, entry := range entries {
+		if commandLineFlags[entry.name] {
+			continue
+		}
+		if err := fs.Set(entry.name, entry.value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %v", filename, entry.line, entry.value, entry.name, err)
+		}
+	}
+	return nil
+}
+
+// configEntry sets the default of the flag name to value.
+type configEntry struct {
+	name  string
+	value string
+	line  int
+}
+
+var (
+	// commandLineFlags are the names of the flags given on the command line, which configurations do not
+	// override.
+	commandLineFlags = make(map[string]bool)
+
+	// dirOptions caches the options of the files of every directory processed, keyed by its absolute path.
+	dirOptions   = make(map[string]cachedOptions)
+	dirOptionsMu sync.Mutex
+)
+
+// cachedOptions are the options of a directory, which are valid as long as the configuration which applies
+// to it is the same file, with the same size and modification time. watch and serve keep running while
+// configurations are created, edited and deleted.
+type cachedOptions struct {
+	config  string
+	size    int64
+	modTime time.Time
+	opts    *options
+}
+
+// applyConfig records the flags given on the command line parsed by fs, and checks the configuration which
+// applies to the current directory, or the directory of -stdin-filename, so that an invalid configuration is
+// reported before processing any file.
+func applyConfig(fs *flag.FlagSet) error {
+	fs.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	if commandLineFlags["g"] {
+		commandLineFlags["generated"] = true
+	}
+	filename := stdinName
+	if *stdinFilename != "" {
+		filename = *stdinFilename
+	}
+	_, err := optionsFor(filename)
+	return err
+}
+
+// optionsFor returns the options to process filename with: the flags given on the command line and, for the
+// others, the values of the configuration in the directory of filename or its closest parent. The options
+// are cached per directory until its configuration changes, and must not be modified.
+func optionsFor(filename string) (*options, error) {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	config, err := findConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	cached := cachedOptions{config: config}
+	if config != "" {
+		fi, err := os.Stat(config)
+		if err != nil {
+			return nil, err
+		}
+		cached.size, cached.modTime = fi.Size(), fi.ModTime()
+	}
+
+	dirOptionsMu.Lock()
+	defer dirOptionsMu.Unlock()
+	c, ok := dirOptions[dir]
+	if ok && c.config == cached.config && c.size == cached.size && c.modTime.Equal(cached.modTime) {
+		return c.opts, nil
+	}
+
+	cached.opts = flagOptions()
+	if config != "" {
+		data, err := ioutil.ReadFile(config)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%v", config, err)
+		}
+		if err := cached.opts.apply(config, entries); err != nil {
+			return nil, err
+		}
+	}
+	dirOptions[dir] = cached
+	return cached.opts, nil
+}
+
+// findConfig returns the path of the configuration in dir or its closest parent, or an empty string if there
+// is none.
+func findConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		filename := filepath.Join(dir, configName)
+		if fi, err := os.Stat(filename); err == nil && !fi.IsDir() {
+			return filename, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// parseConfig parses a configuration made of flag=value lines, where blank lines and lines starting with #
+// are ignored. Every name must be one of the flags of options, without its leading dash, and values may be
+// quoted.
+func parseConfig(data []byte) ([]configEntry, error) {
+	entries := make([]configEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%d: expected flag=value, found %q", n, line)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: invalid quoted value %s", n, value)
+			}
+			value = unquoted
+		}
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%d: unknown flag %q", n, name)
+		}
+		if new(options).flagSet().Lookup(name) == nil {
+			return nil, fmt.Errorf("%d: flag %q cannot be set in a configuration", n, name)
+		}
+		entries = append(entries, configEntry{name: name, value: value, line: n})
+	}
+	return entries, nil
+}
+
+// formatConfig formats entries as a configuration, preceded by the comment lines of header.
+func formatConfig(header []string, entries []configEntry) []byte {
+	buffer := bytes.Buffer{}
+	for _, line := range header {
+		buffer.WriteString("# " + line + "\n")
+	}
+	for _, entry := range entries {
+		value := entry.value
+		if value != strings.TrimSpace(value) || strings.HasPrefix(value, `"`) {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(entry.name + "=" + value + "\n")
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	entries, err := parseConfig([]byte("# comment\n\nlocal = example.com/foo\nblank-comment=\" side effects\"\n"))
+	require.NoError(t, err)
+	require.Equal(t, []configEntry{
+		{name: "local", value: "example.com/foo", line: 3},
+		{name: "blank-comment", value: " side effects", line: 4},
+	}, entries)
+	require.Equal(t, "# header\nlocal=example.com/foo\nblank-comment=\" side effects\"\n", string(formatConfig([]string{"header"}, entries)))
+
+	_, err = parseConfig([]byte("local\n"))
+	require.EqualError(t, err, `1: expected flag=value, found "local"`)
+	_, err = parseConfig([]byte("\nunknown=1\n"))
+	require.EqualError(t, err, `2: unknown flag "unknown"`)
+	_, err = parseConfig([]byte("local=example.com/foo\nw=true\n"))
+	require.EqualError(t, err, `2: flag "w" cannot be set in a configuration`)
+	_, err = parseConfig([]byte("backup-dir=/tmp\n"))
+	require.EqualError(t, err, `1: flag "backup-dir" cannot be set in a configuration`)
+	new(options).flagSet().VisitAll(func(f *flag.Flag) {
+		require.NotNil(t, flag.Lookup(f.Name), f.Name)
+	})
+}
+
+func TestApplyConfig(t *testing.T) {
+	defer func(loc, name string) {
+		*local, *stdinFilename = loc, name
+		commandLineFlags = make(map[string]bool)
+	}(*local, *stdinFilename)
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "pkg"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, configName), []byte("local=example.com/foo\nblank-group=true\n"), 0644))
+
+	filename, err := findConfig(filepath.Join(dir, "pkg"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, configName), filename)
+
+	// the flags are left alone, flags given on the command line win over the configuration
+	*stdinFilename = filepath.Join(dir, "pkg", "foo.go")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	require.NoError(t, fs.Parse([]string{"-local", "example.com/bar"}))
+	require.NoError(t, applyConfig(fs))
+	require.Equal(t, "example.com/bar", *local)
+	require.False(t, *blankGroup)
+	opts, err := optionsFor(*stdinFilename)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/bar", opts.local)
+	require.True(t, opts.blankGroup)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pkg", configName), []byte("\ngenerated=sometimes\n"), 0644))
+	_, err = optionsFor(filepath.Join(dir, "pkg", "sub", "bar.go"))
+	require.EqualError(t, err, filepath.Join(dir, "pkg", configName)+`:2: invalid value "sometimes" for generated: expected skip, include or only`)
+}
+
+func TestOptionsFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		filepath.Join("a", configName):    "local=example.com/a\n",
+		filepath.Join("b", configName):    "local=example.com/b\nblank-group=true\n",
+		filepath.Join("a", "a.go"):        "package a\n\nimport (\n\t_ \"example.com/x\"\n\t\"example.com/a/b\"\n\t\"example.com/b/c\"\n)\n",
+		filepath.Join("b", "sub", "b.go"): "package b\n\nimport (\n\t_ \"example.com/x\"\n\t\"example.com/a/b\"\n\t\"example.com/b/c\"\n)\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	// every file is regrouped with the configuration of its own directory
+	var buf bytes.Buffer
+	require.NoError(t, processPath(filepath.Join(dir, "a", "a.go"), &buf))
+	require.Equal(t, "package a\n\nimport (\n\t\"example.com/b/c\"\n\n\t_ \"example.com/x\"\n\n\t\"example.com/a/b\"\n)\n", buf.String())
+	buf.Reset()
+	require.NoError(t, processPath(filepath.Join(dir, "b", "sub", "b.go"), &buf))
+	require.Equal(t, "package b\n\nimport (\n\t\"example.com/a/b\"\n\n\t\"example.com/b/c\"\n\n\t_ \"example.com/x\"\n)\n", buf.String())
+
+	// the options are resolved once per directory
+	opts, err := optionsFor(filepath.Join(dir, "b", "sub", "c.go"))
+	require.NoError(t, err)
+	again, err := optionsFor(filepath.Join(dir, "b", "sub", "d.go"))
+	require.NoError(t, err)
+	require.True(t, opts == again)
+	require.Equal(t, "example.com/b", opts.local)
+}
+
+func TestOptionsFor_Changed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "pkg", "foo.go")
+	config := filepath.Join(dir, configName)
+
+	opts, err := optionsFor(filename)
+	require.NoError(t, err)
+	require.Empty(t, opts.local)
+
+	// a configuration created, edited or deleted while watch or serve run is taken into account
+	require.NoError(t, ioutil.WriteFile(config, []byte("local=example.com/a\n"), 0644))
+	opts, err = optionsFor(filename)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/a", opts.local)
+
+	require.NoError(t, ioutil.WriteFile(config, []byte("local=example.com/b\n"), 0644))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(config, later, later))
+	opts, err = optionsFor(filename)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/b", opts.local)
+
+	require.NoError(t, os.Remove(config))
+	opts, err = optionsFor(filename)
+	require.NoError(t, err)
+	require.Empty(t, opts.local)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+const resolveConflictsCmd = "resolve-conflicts"
+
+var (
+	conflictStartRegex = regexp.MustCompile(`^<{7}(\s.*)?$`)
+	conflictBaseRegex  = regexp.MustCompile(`^\|{7}(\s.*)?$`)
+	conflictSepRegex   = regexp.MustCompile(`^={7}$`)
+	conflictEndRegex   = regexp.MustCompile(`^>{7}(\s.*)?$`)
+
+	// resolveConflicts is set by the resolve-conflicts command.
+	resolveConflicts bool
+)
+
+// resolveConflictsMain runs the resolve-conflicts command, which behaves like go-groups itself but first
+// resolves merge conflicts inside import blocks. With -driver it runs as a git merge driver.
+func resolveConflictsMain(args []string) int {
+	fs := flag.NewFlagSet(resolveConflictsCmd, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	driver := fs.Bool("driver", false, "run as a git merge driver, taking the %O %A %B arguments")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] [path ...]\n", os.Args[0], resolveConflictsCmd)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s %s -driver ancestor current other\n", os.Args[0], resolveConflictsCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if err := applyConfig(fs); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		return exitBadFlags
+	}
+
+	resolveConflicts = true
+	if *driver {
+		return mergeDriver(fs.Args())
+	}
+	run(fs.Args())
+	return 0
+}
+
+// mergeDriver merges the current and other versions of a file with git merge-file, resolves the conflicts
+// inside import blocks and writes the result to current. It exits with 1 if conflicts remain, as git expects.
+//
+// It can be configured with:
+//
+//	git config merge.go-groups.driver "go-groups resolve-conflicts -driver %O %A %B"
+//	echo "*.go merge=go-groups" >> .gitattributes
+func mergeDriver(args []string) int {
+	if len(args) != 3 {
+		_, _ = fmt.Fprintln(os.Stderr, "error: -driver expects the ancestor, current and other files")
+		return exitBadFlags
+	}
+	ancestor, current, other := args[0], args[1], args[2]
+
+	merged, err := exec.Command("git", "merge-file", "-p", "--", current, ancestor, other).Output()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() > 0) {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to merge "+current+": "+err.Error())
+		return exitInternalError
+	}
+
+	fi, err := os.Stat(current)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to merge "+current+": "+err.Error())
+		return exitInternalError
+	}
+	if err := ioutil.WriteFile(current, merged, fi.Mode().Perm()); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to merge "+current+": "+err.Error())
+		return exitInternalError
+	}
+
+	*write = true
+	if err := processPath(current, os.Stdout); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to regroup "+current+": "+err.Error())
+	}
+
+	src, err := ioutil.ReadFile(current)
+	if err != nil || hasConflictMarkers(src) {
+		return 1
+	}
+	return 0
+}
+
+// hasConflictMarkers reports whether src contains any merge conflict markers.
+func hasConflictMarkers(src []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		if conflictStartRegex.Match(scanner.Bytes()) || conflictEndRegex.Match(scanner.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveImportConflicts resolves merge conflicts which lie entirely inside an import block by taking the
+// union of both sides and dropping the common ancestor of diff3 style conflicts. The duplicates this may
+// introduce are removed when regrouping. Conflicts outside of import blocks are left untouched.
+func resolveImportConflicts(src []byte) (result []byte, resolved bool) {
+	lines := make([]string, 0, 128)
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	buffer := bytes.Buffer{}
+	insideImports := false
+	for n := 0; n < len(lines); n++ {
+		line := lines[n]
+		switch {
+		case insideImports && conflictStartRegex.MatchString(line):
+			if union, end, ok := importConflictUnion(lines, n); ok {
+				for _, l := range union {
+					buffer.WriteString(l)
+					buffer.WriteString("\n")
+				}
+				n = end
+				resolved = true
+				continue
+			}
+		case insideImports && importEndRegex.MatchString(line):
+			insideImports = false
+		case importStartRegex.MatchString(line):
+			insideImports = true
+		}
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+	}
+	if !resolved {
+		return src, false
+	}
+	return buffer.Bytes(), true
+}
+
+// importConflictUnion returns both sides of the conflict starting at lines[start] and the line number of its
+// end marker. It fails if the conflict is not terminated or reaches outside of the import block.
+func importConflictUnion(lines []string, start int) (union []string, end int, ok bool) {
+	inBase := false
+	for n := start + 1; n < len(lines); n++ {
+		line := lines[n]
+		switch {
+		case conflictEndRegex.MatchString(line):
+			return union, n, true
+		case conflictBaseRegex.MatchString(line):
+			inBase = true
+		case conflictSepRegex.MatchString(line):
+			inBase = false
+		case conflictStartRegex.MatchString(line), importStartRegex.MatchString(line), importEndRegex.MatchString(line):
+			return nil, 0, false
+		case !inBase:
+			union = append(union, line)
+		}
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveImportConflicts(t *testing.T) {
+	defer func(r bool) { resolveConflicts = r }(resolveConflicts)
+	resolveConflicts = true
+
+	var buf bytes.Buffer
+	src := testdata(t, "import_conflicts_invalid.txt")
+	require.NoError(t, processFile("", bytes.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, string(testdata(t, "import_conflicts.txt")), buf.String())
+}
+
+func TestResolveImportConflicts_OutsideImportBlock(t *testing.T) {
+	src := strings.Join([]string{
+		"package main",
+		"",
+		"<<<<<<< HEAD",
+		"import (",
+		"\t\"fmt\"",
+		")",
+		"=======",
+		"import (",
+		"\t\"os\"",
+		")",
+		">>>>>>> feature",
+		"",
+	}, "\n")
+	res, resolved := resolveImportConflicts([]byte(src))
+	require.False(t, resolved)
+	require.Equal(t, src, string(res))
+}
+
+func TestMergeDriver(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("skip test: git is required")
+	}
+	defer func(r, w bool) { resolveConflicts, *write = r, w }(resolveConflicts, *write)
+	resolveConflicts = true
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.go":   "package main\n\nimport (\n\t\"fmt\"\n)\n",
+		"ours.go":   "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n",
+		"theirs.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"github.com/pkg/errors\"\n)\n",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	code := mergeDriver([]string{filepath.Join(dir, "base.go"), filepath.Join(dir, "ours.go"), filepath.Join(dir, "theirs.go")})
+	require.Equal(t, 0, code)
+
+	merged, err := ioutil.ReadFile(filepath.Join(dir, "ours.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/pkg/errors\"\n)\n", string(merged))
+}
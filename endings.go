@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+)
+
+var (
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+	crlf    = []byte("\r\n")
+	lf      = []byte("\n")
+)
+
+// lineEndings describes the byte order mark and line ending style of a source file.
+type lineEndings struct {
+	bom  bool
+	crlf bool
+	// mixed is set if the file uses both CRLF and LF line endings, which cannot be restored once lines
+	// are moved around.
+	mixed bool
+}
+
+// detectLineEndings strips the byte order mark of src and converts CRLF line endings to LF, returning
+// the normalized source and its original style. Files with mixed line endings are considered to use
+// the style most of their lines use.
+func detectLineEndings(src []byte) ([]byte, lineEndings) {
+	var endings lineEndings
+	if bytes.HasPrefix(src, utf8BOM) {
+		endings.bom = true
+		src = src[len(utf8BOM):]
+	}
+	if n := bytes.Count(src, crlf); n > 0 {
+		lfOnly := bytes.Count(src, lf) - n
+		endings.crlf = n > lfOnly
+		endings.mixed = lfOnly > 0
+		src = bytes.ReplaceAll(src, crlf, lf)
+	}
+	return src, endings
+}
+
+// restore converts normalized source back to the given line ending style.
+func (e lineEndings) restore(src []byte) []byte {
+	if e.crlf {
+		src = bytes.ReplaceAll(src, lf, crlf)
+	}
+	if e.bom {
+		src = append(append(make([]byte, 0, len(utf8BOM)+len(src)), utf8BOM...), src...)
+	}
+	return src
+}
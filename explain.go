@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+const explainCmd = "explain"
+
+// explainMain runs the explain command, which prints the group of every import of a file, or of a single
+// import path, and the rule which put it there.
+func explainMain(args []string) int {
+	fs := flag.NewFlagSet(explainCmd, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] file|import-path ...\n", os.Args[0], explainCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if err := applyConfig(fs); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		return exitBadFlags
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitBadFlags
+	}
+
+	code := 0
+	for _, arg := range fs.Args() {
+		var opts *options
+		var err error
+		fi, statErr := os.Stat(arg)
+		switch {
+		case statErr == nil && !fi.IsDir():
+			if opts, err = optionsFor(arg); err == nil {
+				err = explainFile(os.Stdout, arg, opts)
+			}
+		case isFilePath(arg):
+			err = statErr
+			if err == nil {
+				err = errors.New("is a directory")
+			}
+		default:
+			if opts, err = optionsFor(stdinName); err == nil {
+				err = explainImport(os.Stdout, arg, opts)
+			}
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "cannot explain "+arg+": "+err.Error())
+			code = exitFileErrors
+		}
+	}
+	return code
+}
+
+// isFilePath reports whether arg names a file rather than an import path: a Go file, an absolute path, a
+// path relative to the current directory or one using a separator other than a slash.
+func isFilePath(arg string) bool {
+	if strings.HasSuffix(arg, ".go") || filepath.IsAbs(arg) || arg == "." || arg == ".." {
+		return true
+	}
+	if strings.HasPrefix(arg, "./") || strings.HasPrefix(arg, "../") {
+		return true
+	}
+	return filepath.Separator != '/' && strings.ContainsRune(arg, filepath.Separator)
+}
+
+// explainImport explains the group of a single import path with opts.
+func explainImport(out io.Writer, path string, opts *options) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	group, rule := explainClass(`"`+path+`"`, opts)
+	_, _ = fmt.Fprintf(w, "%q\t%s\t%s\n", path, group, rule)
+	return w.Flush()
+}
+
+// explainFile explains the group of every import in the import blocks of filename with opts, followed by the
+// regrouped import blocks.
+func explainFile(out io.Writer, filename string, opts *options) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	src, _ = detectLineEndings(src)
+	_, _, groups := parseImportGroups(src, opts)
+	if len(groups) == 0 {
+		_, err = fmt.Fprintf(out, "%s: no import blocks\n", filename)
+		return err
+	}
+
+	for _, group := range groups {
+		_, _ = fmt.Fprintf(out, "%s:%d: import block\n", filename, group.lineStart+1)
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, line := range group.lines {
+			path := importPath(line.line)
+			if path == "" {
+				continue
+			}
+			name, rule := explainClass(line.line, opts)
+			spec := strings.TrimSpace(commentRegex.ReplaceAllString(line.line, ""))
+			_, _ = fmt.Fprintf(w, "  %d:\t%s\t%s\t%s\n", line.lineNum+1, spec, name, rule)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		buffer := bytes.Buffer{}
+		regrouped := regroupImportGroups(group, opts)
+		writeImportGroup(&buffer, regrouped, opts)
+		if regrouped.cgo != nil {
+			buffer.WriteString("\n")
+			writeCgoImport(&buffer, *regrouped.cgo)
+		}
+		_, _ = fmt.Fprintln(out, "final order:")
+		if _, err := out.Write(buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainClass returns the name of the group of an import line, as used to order the groups, and the rule
+// of classifyImport which put it there.
+func explainClass(line string, opts *options) (group, rule string) {
+	if importPath(line) == cgoImportPath {
+		return "cgo", `"C" is kept in its own declaration below its preamble`
+	}
+	class, groupName, rule := classifyImport(line, opts)
+	switch class {
+	case externalClass:
+		return fmt.Sprintf("third party %q", groupName), rule
+	case localClass:
+		return "local", rule
+	case dotClass:
+		return "dot imports", rule
+	case blankClass:
+		return "blank imports", rule
+	}
+	return "standard library", rule
+}
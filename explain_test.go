@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainClass(t *testing.T) {
+	defer func(blank, dot bool, loc string) {
+		*blankGroup, *dotGroup, *local = blank, dot, loc
+	}(*blankGroup, *dotGroup, *local)
+	*blankGroup, *local = true, "example.com/local"
+
+	for line, group := range map[string]string{
+		`"fmt"`:                        "standard library",
+		`"C"`:                          "cgo",
+		`_ "github.com/lib/pq"`:        "blank imports",
+		`. "github.com/onsi/gomega"`:   `third party "github.comonsi"`,
+		`"example.com/local/pkg"`:      "local",
+		`"gopkg.in/yaml.v2"`:           `third party "gopkg.inyaml"`,
+		`foo "github.com/pkg/errors"`:  `third party "github.compkg"`,
+		`"internal.local/foo_bar.baz"`: `third party "internal.localfoo_bar"`,
+	} {
+		name, rule := explainClass(line, flagOptions())
+		require.Equal(t, group, name, line)
+		require.NotEmpty(t, rule, line)
+	}
+
+	_, rule := explainClass(`"example.com/local/pkg"`, flagOptions())
+	require.Equal(t, `starts with the -local prefix "example.com/local"`, rule)
+	_, rule = explainClass(`"oss.indeed.com/go/go-groups"`, flagOptions())
+	require.Equal(t, `starts with a domain, grouped by the first and last labels of the domain and the first path element: "oss" + ".com" + "go"`, rule)
+}
+
+func TestIsFilePath(t *testing.T) {
+	for arg, expected := range map[string]bool{
+		"main.go":               true,
+		"./cmd":                 true,
+		"../go-groups":          true,
+		".":                     true,
+		"fmt":                   false,
+		"github.com/pkg/errors": false,
+		"gopkg.in/yaml.v2":      false,
+	} {
+		require.Equal(t, expected, isFilePath(arg), arg)
+	}
+
+	require.Equal(t, exitFileErrors, explainMain([]string{"testdata/missing.go"}))
+	require.Equal(t, exitFileErrors, explainMain([]string{"./testdata"}))
+}
+
+// TestExplainFile_Order checks that the groups explain reports are the groups go-groups writes.
+func TestExplainFile_Order(t *testing.T) {
+	defer func(blank bool, loc string) { *blankGroup, *local = blank, loc }(*blankGroup, *local)
+	*blankGroup, *local = true, "oss.indeed.com/go/go-groups"
+
+	filename := "testdata/explain_order.txt"
+	var buf bytes.Buffer
+	require.NoError(t, explainFile(&buf, filename, flagOptions()))
+	groups := make(map[string]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if fields := regexp.MustCompile(`^  \d+:\s+(.*?"[^"]*")\s+(.*?)  +`).FindStringSubmatch(line); fields != nil {
+			groups[fields[1]] = fields[2]
+		}
+	}
+	require.Len(t, groups, 10)
+	explained := buf.String()
+
+	buf.Reset()
+	require.NoError(t, processFile(filename, bytes.NewReader(testdata(t, "explain_order.txt")), &buf, true, flagOptions()))
+	block := extractImportBlock(t, buf.Bytes())
+	require.Contains(t, explained, "final order:\n"+block)
+
+	// the imports of a group share its name, and no two groups have the same name
+	seen := make(map[string]bool)
+	for _, section := range strings.Split(block, "\n\n") {
+		name := ""
+		for _, line := range strings.Split(section, "\n") {
+			spec := strings.TrimSpace(line)
+			if !strings.HasSuffix(spec, `"`) {
+				continue
+			}
+			if name == "" {
+				name = groups[spec]
+				require.False(t, seen[name], "group %s is split", name)
+				seen[name] = true
+			}
+			require.Equal(t, name, groups[spec], spec)
+		}
+	}
+}
+
+func TestExplainFile(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, explainFile(&buf, "testdata/external_groups_invalid.txt", flagOptions()))
+	lines := strings.Split(buf.String(), "\n")
+	require.Equal(t, "testdata/external_groups_invalid.txt:3: import block", lines[0])
+	require.Regexp(t, `^  4: +"io" +standard library +the path contains no dot`, lines[1])
+	require.Contains(t, buf.String(), "final order:\n"+extractImportBlock(t, testdata(t, "external_groups.txt")))
+
+	buf.Reset()
+	require.NoError(t, explainImport(&buf, "github.com/pkg/errors", flagOptions()))
+	require.Regexp(t, `^"github.com/pkg/errors" +third party "github.compkg" +starts with a domain`, buf.String())
+}
+
+// extractImportBlock returns the first import block of src.
+func extractImportBlock(t *testing.T, src []byte) string {
+	s := string(src)
+	start := strings.Index(s, "import (")
+	end := strings.Index(s[start:], "\n)\n")
+	require.True(t, start >= 0 && end >= 0)
+	return s[start : start+end+3]
+}
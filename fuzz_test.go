@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzParse checks that the output of parse is a fixed point for valid go source. The fixtures in testdata are used as
+// seed corpus, so they are verified by every go test run.
+func FuzzParse(f *testing.F) {
+	fixtures, err := filepath.Glob("testdata/*.txt")
+	require.NoError(f, err)
+	for _, fixture := range fixtures {
+		src, err := ioutil.ReadFile(fixture)
+		require.NoError(f, err)
+		f.Add(src)
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		// like go-groups itself, only consider valid go source, as gofmt would reject anything else
+		if _, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments); err != nil {
+			return
+		}
+		if _, err := checkAliases(src, "", flagOptions()); err != nil {
+			return
+		}
+		res, rewritten := parse(src, flagOptions())
+		if !rewritten {
+			return
+		}
+		again, _ := parse(res, flagOptions())
+		require.Equal(t, string(res), string(again))
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// generatedPolicy selects which files are processed depending on whether they contain generated code.
+type generatedPolicy string
+
+const (
+	generatedSkip    generatedPolicy = "skip"
+	generatedInclude generatedPolicy = "include"
+	generatedOnly    generatedPolicy = "only"
+
+	// skippedGenerated is the reason reported for the generated files skipped by the policy.
+	skippedGenerated = "generated"
+	// skippedNotGenerated is the reason reported for the other files skipped by -generated=only.
+	skippedNotGenerated = "not generated"
+)
+
+func isValidGeneratedPolicy(policy string) bool {
+	switch generatedPolicy(policy) {
+	case generatedSkip, generatedInclude, generatedOnly:
+		return true
+	}
+	return false
+}
+
+// policyValue is the flag.Value of a generatedPolicy.
+type policyValue generatedPolicy
+
+func (p *policyValue) String() string {
+	return string(*p)
+}
+
+func (p *policyValue) Set(value string) error {
+	if !isValidGeneratedPolicy(value) {
+		return errors.New("expected skip, include or only")
+	}
+	*p = policyValue(value)
+	return nil
+}
+
+// generatedMode returns the policy selected by -generated, where -g stands for include.
+func generatedMode() generatedPolicy {
+	if *genCode && generatedPolicy(*generated) == generatedSkip {
+		return generatedInclude
+	}
+	return generatedPolicy(*generated)
+}
+
+// skipGenerated reports whether the generated policy of opts skips filename, whose contents are src.
+func skipGenerated(filename string, src []byte, opts *options) bool {
+	if opts.generated == generatedInclude {
+		return false
+	}
+	return isGeneratedFile(filename, src, opts) != (opts.generated == generatedOnly)
+}
+
+// isGeneratedFile reports whether filename matches one of the -generated-files patterns or its contents src
+// are marked as generated.
+func isGeneratedFile(filename string, src []byte, opts *options) bool {
+	name := filepath.Base(filename)
+	for _, pattern := range splitList(opts.generatedFiles) {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return isGeneratedCode(src, opts)
+}
+
+// isGeneratedCode reports whether a comment before the package clause of src marks it as generated, either
+// with the standard "Code generated ... DO NOT EDIT." line or one of the -generated-markers.
+func isGeneratedCode(src []byte, opts *options) bool {
+	inComment := false
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inComment:
+			inComment = !strings.Contains(trimmed, "*/")
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "//"):
+			if generatedRegex.MatchString(line) {
+				return true
+			}
+		case strings.HasPrefix(trimmed, "/*"):
+			inComment = !strings.Contains(trimmed[2:], "*/")
+		default:
+			// the package clause
+			return false
+		}
+		for _, marker := range splitList(opts.generatedMarkers) {
+			if strings.Contains(trimmed, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitList returns the non-empty elements of a comma-separated flag value.
+func splitList(value string) []string {
+	elements := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsGeneratedFile(t *testing.T) {
+	defer func(markers, files string) { *generatedMarkers, *generatedFiles = markers, files }(*generatedMarkers, *generatedFiles)
+
+	require.True(t, isGeneratedFile("foo.go", []byte("// Code generated by foo. DO NOT EDIT.\r\npackage foo\r\n"), flagOptions()))
+	require.True(t, isGeneratedFile("foo.go", []byte("/*\nLicense\n*/\n\n// Code generated by foo. DO NOT EDIT.\n\npackage foo\n"), flagOptions()))
+	require.False(t, isGeneratedFile("foo.go", []byte("package foo\n\n// Code generated by foo. DO NOT EDIT.\n"), flagOptions()))
+	require.False(t, isGeneratedFile("foo.go", []byte("/*\n// Code generated by foo. DO NOT EDIT.\n*/\npackage foo\n"), flagOptions()))
+	require.False(t, isGeneratedFile("foo.pb.go", []byte("package foo\n"), flagOptions()))
+
+	*generatedMarkers = "@generated, autogenerated by"
+	require.True(t, isGeneratedFile("foo.go", []byte("// @generated\npackage foo\n"), flagOptions()))
+	require.True(t, isGeneratedFile("foo.go", []byte("/*\n * This file was autogenerated by foo.\n */\npackage foo\n"), flagOptions()))
+	require.False(t, isGeneratedFile("foo.go", []byte("package foo\n// @generated\n"), flagOptions()))
+
+	*generatedFiles = "*.pb.go, zz_generated.*"
+	require.True(t, isGeneratedFile("pkg/foo.pb.go", []byte("package foo\n"), flagOptions()))
+	require.True(t, isGeneratedFile("pkg/zz_generated.deepcopy.go", []byte("package foo\n"), flagOptions()))
+	require.False(t, isGeneratedFile("pkg/foo.go", []byte("package foo\n"), flagOptions()))
+}
+
+func TestGeneratedMode(t *testing.T) {
+	defer func(g bool, policy string) { *genCode, *generated = g, policy }(*genCode, *generated)
+
+	require.Equal(t, generatedSkip, generatedMode())
+	*genCode = true
+	require.Equal(t, generatedInclude, generatedMode())
+	*generated = string(generatedOnly)
+	require.Equal(t, generatedOnly, generatedMode())
+}
@@ -31,9 +31,10 @@ package main
 */
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	goformat "go/format"
 	"go/scanner"
 	"io"
 	"io/ioutil"
@@ -42,51 +43,67 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 )
 
-func isGeneratedCode(src []byte) bool {
-	scanner := bufio.NewScanner(bytes.NewReader(src))
-	for scanner.Scan() {
-		if generatedRegex.MatchString(scanner.Text()) {
-			return true
-		}
-	}
-	return false
-}
-
 // based on https://golang.org/src/cmd/gofmt/gofmt.go with a few modifications
 
 func isGoFile(f os.FileInfo) bool {
+	return !f.IsDir() && !isExcluded(f.Name())
+}
+
+// isExcluded reports whether the file at path is skipped when walking directories, which also applies to
+// standard input named by -stdin-filename. The file does not need to exist.
+func isExcluded(path string) bool {
 	// ignore non-Go files
-	name := f.Name()
-	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".go")
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".go")
+}
+
+// processPath processes the file at path with the options of its directory, see optionsFor.
+func processPath(path string, out io.Writer) error {
+	opts, err := optionsFor(path)
+	if err != nil {
+		return err
+	}
+	return processFile(path, nil, out, !*noFormat, opts)
 }
 
 // If in == nil, the source is the contents of the file with the given filename.
-func processFile(filename string, in io.Reader, out io.Writer, fixFmt, generatedCode bool) error {
-	var perm os.FileMode = 0644
+func processFile(filename string, in io.Reader, out io.Writer, fixFmt bool, opts *options) error {
+	var fi os.FileInfo
 	if in == nil {
 		f, err := os.Open(filename)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		fi, err := f.Stat()
+		fi, err = f.Stat()
 		if err != nil {
 			return err
 		}
 		in = f
-		perm = fi.Mode().Perm()
 	}
 
 	src, err := ioutil.ReadAll(in)
 	if err != nil {
 		return err
 	}
+	raw := src
+	src, inEndings := detectLineEndings(src)
+	original := src
+	outEndings := inEndings
+	if *normalizeLF {
+		outEndings.crlf, outEndings.mixed = false, false
+	}
 
-	if !generatedCode && isGeneratedCode(src) {
-		if !*list && !*write && !*doDiff {
-			_, err = out.Write(src)
+	skipped := skipGenerated(filename, src, opts)
+	if skipped && *list && opts.generated == generatedSkip {
+		_, _ = fmt.Fprintln(os.Stderr, filename+": skipped generated file")
+	}
+	if skipped || isBuildExcluded(filename, src, opts) {
+		if printSource() {
+			_, err = out.Write(raw)
 			if err != nil {
 				return err
 			}
@@ -94,39 +111,67 @@ func processFile(filename string, in io.Reader, out io.Writer, fixFmt, generated
 		return nil
 	}
 
+	resolved := false
+	if resolveConflicts {
+		src, resolved = resolveImportConflicts(src)
+		// gofmt cannot parse the conflicts left outside of import blocks
+		fixFmt = fixFmt && !hasConflictMarkers(src)
+	}
+
+	unformatted := src
 	if fixFmt {
-		buffer := bytes.Buffer{}
-		buffer.Write(src)
-		cmd := exec.Command("gofmt", "--")
-		cmd.Stdin = &buffer
-		cmd.Stderr = os.Stderr
-		src, err = cmd.Output()
+		src, err = gofmt(src, filename)
 		if err != nil {
 			return err
 		}
 	}
 
-	res, rewritten := parse(src)
-	if !bytes.Equal(src, res) && rewritten {
+	warnings, err := checkAliases(unformatted, filename, opts)
+	if err != nil {
+		return err
+	}
+	printWarnings(warnings)
+	res := regroup(src, opts)
+	if *verifyStable {
+		if err := checkStable(res, filename, fixFmt, opts); err != nil {
+			return err
+		}
+	}
+	changed := !bytes.Equal(src, res) || resolved || inEndings != outEndings
+	if outEndings.mixed && (changed && *write || printSource() && !bytes.Equal(res, original)) {
+		return fmt.Errorf("%s: refusing to rewrite a file with mixed line endings, use -lf to normalize them", filename)
+	}
+	// conflicts left outside of import blocks cannot be parsed, so the rewrite cannot be verified
+	if changed && !(resolveConflicts && hasConflictMarkers(src)) {
+		if err := verifyRewrite(src, res, opts); err != nil {
+			return fmt.Errorf("%s: refusing to rewrite: %v", filename, err)
+		}
+	}
+	if changed && *format != "" {
+		if err := annotate(out, filename, original, src, *format, opts); err != nil {
+			return err
+		}
+	}
+
+	src, res = inEndings.restore(src), outEndings.restore(res)
+	if changed {
 		// formatting has changed
+		atomic.AddInt32(&changedFiles, 1)
 		if *list {
-			if _, err := fmt.Fprintln(out, filename); err != nil {
+			terminator := "\n"
+			if *nulSeparated {
+				terminator = "\x00"
+			}
+			if _, err := fmt.Fprint(out, filename+terminator); err != nil {
 				return err
 			}
 		}
 		if *write {
-			// make a temporary backup before overwriting original
-			bakname, err := backupFile(filename+".", src, perm)
-			if err != nil {
+			if err := keepBackup(filename, raw, res); err != nil {
 				return err
 			}
-			err = ioutil.WriteFile(filename, res, perm)
-			if err != nil {
-				_ = os.Rename(bakname, filename)
-				return err
-			}
-			err = os.Remove(bakname)
-			if err != nil {
+			if err := writeFile(filename, raw, res, takeSnapshot(fi, raw)); err != nil {
+				discardBackup(filename)
 				return err
 			}
 		}
@@ -143,25 +188,147 @@ func processFile(filename string, in io.Reader, out io.Writer, fixFmt, generated
 		}
 	}
 
-	if !*list && !*write && !*doDiff {
+	if printSource() {
+		if outEndings.mixed {
+			// the file is unchanged, but its line endings cannot be restored
+			res = raw
+		}
 		_, err = out.Write(res)
 	}
 
 	return err
 }
 
+// inProcessFormat formats source with go/format instead of the gofmt command. It is set by the serve
+// command, which saves starting a gofmt process for every request.
+var inProcessFormat bool
+
+// gofmt formats src, the contents of filename, with the gofmt command. The errors reported by gofmt
+// refer to filename.
+func gofmt(src []byte, filename string) ([]byte, error) {
+	if inProcessFormat {
+		res, err := goformat.Source(src)
+		var errs scanner.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				e.Pos.Filename = filename
+			}
+		}
+		return res, err
+	}
+	buffer := bytes.Buffer{}
+	buffer.Write(src)
+	cmd := exec.Command("gofmt", "--")
+	cmd.Stdin = &buffer
+	res, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		msg := strings.ReplaceAll(string(exitErr.Stderr), stdinName, filename)
+		return nil, errors.New(strings.TrimSpace(msg))
+	}
+	return res, err
+}
+
+// regroup rewrites the import blocks of src with opts.
+func regroup(src []byte, opts *options) []byte {
+	res, rewritten := parse(src, opts)
+	if !rewritten {
+		return src
+	}
+	return res
+}
+
+// checkAliases checks the import blocks of src for paths imported under several names, see
+// checkImportAliases, positioning the errors and warnings in filename. src is the source before gofmt,
+// which may move the imports, so that the positions refer to the file as it was read.
+func checkAliases(src []byte, filename string, opts *options) (scanner.ErrorList, error) {
+	_, _, groups := parseImportGroups(src, opts)
+	// blocks found in string literals, such as the sources of tests, are not imports of the file
+	if decls := importDeclLines(src); decls != nil {
+		declared := make([]importGroup, 0, len(groups))
+		for _, group := range groups {
+			for _, decl := range decls {
+				if decl[0] == group.lineStart+1 {
+					declared = append(declared, group)
+					break
+				}
+			}
+		}
+		groups = declared
+	}
+	warnings, err := checkImportAliases(groups)
+	for _, w := range warnings {
+		w.Pos.Filename = filename
+	}
+	var errs scanner.ErrorList
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			e.Pos.Filename = filename
+		}
+	}
+	return warnings, err
+}
+
+// printWarnings prints the warnings found while regrouping a file, which does not fail because of them.
+func printWarnings(warnings scanner.ErrorList) {
+	for _, w := range warnings {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: warning: %s\n", w.Pos, w.Msg)
+	}
+}
+
+// checkStable runs the formatting pipeline a second time over its result res, and fails
+// with a diff of the two results if res is not a fixed point.
+func checkStable(res []byte, filename string, fixFmt bool, opts *options) error {
+	again := res
+	if fixFmt {
+		var err error
+		if again, err = gofmt(again, filename); err != nil {
+			return fmt.Errorf("%s: output is not stable: gofmt failed on the output: %v", filename, err)
+		}
+	}
+	again = regroup(again, opts)
+	if bytes.Equal(res, again) {
+		return nil
+	}
+	data, err := diff(res, again, filename)
+	if err != nil {
+		return fmt.Errorf("%s: output is not stable", filename)
+	}
+	return fmt.Errorf("%s: output is not stable, formatting it again changes it:\n%s", filename, data)
+}
+
+// printSource reports whether the (possibly rewritten) source is written to the output,
+// which is the case unless one of the listing, writing, diffing or annotating modes is used.
+func printSource() bool {
+	return !*list && !*write && !*doDiff && *format == ""
+}
+
 func visitFile(path string, f os.FileInfo, err error) error {
+	if isInterrupted() {
+		return errInterrupted
+	}
+	if err == nil && f.IsDir() && isBackupDir(path) {
+		return filepath.SkipDir
+	}
 	if err == nil && isGoFile(f) {
-		err = processFile(path, nil, os.Stdout, !*noFormat, *genCode)
+		err = processPath(path, os.Stdout)
+		atomic.AddInt32(&processedFiles, 1)
 	}
 	// Don't complain if a file was deleted in the meantime (i.e.
 	// the directory changed concurrently while running gofmt).
 	if err != nil && !os.IsNotExist(err) {
-		scanner.PrintError(os.Stderr, err)
+		reportError(err)
 	}
 	return nil
 }
 
+// reportError prints an error which occurred while processing a file, with the position of every error
+// of a scanner.ErrorList, and records it for the exit code.
+func reportError(err error) {
+	scanner.PrintError(os.Stderr, err)
+	atomic.AddInt32(&failedFiles, 1)
+}
+
 func walkDir(path string) error {
 	return filepath.Walk(path, visitFile)
 }
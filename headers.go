@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const groupPlaceholder = "{group}"
+
+// filterHeaders removes the group header comments generated by go-groups, so that regrouping
+// replaces them rather than attaching them to the import which happened to follow them.
+func filterHeaders(patterns []*regexp.Regexp, lines []string) []string {
+	if len(patterns) == 0 {
+		return lines
+	}
+	filtered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !isGroupHeader(patterns, line) {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered
+}
+
+func isGroupHeader(patterns []*regexp.Regexp, line string) bool {
+	line = strings.TrimSpace(line)
+	for _, pattern := range patterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupHeaders returns patterns matching the header comments go-groups places above import groups with opts.
+func groupHeaders(opts *options) []*regexp.Regexp {
+	templates := make([]string, 0, 5)
+	if opts.headers {
+		templates = append(templates, opts.stdHeader, opts.externalHeader, opts.localHeader)
+	}
+	if opts.blankGroup {
+		templates = append(templates, opts.blankComment)
+	}
+	if opts.dotGroup {
+		templates = append(templates, opts.dotComment)
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(templates))
+	for _, template := range templates {
+		if template == "" {
+			continue
+		}
+		pattern := regexp.QuoteMeta(headerComment(template))
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(groupPlaceholder), ".+")
+		patterns = append(patterns, regexp.MustCompile("^"+pattern+"$"))
+	}
+	return patterns
+}
+
+// headerLine renders a header template for the named group as a line of an import block.
+func headerLine(template, groupName string) importLine {
+	return importLine{line: "\t" + headerComment(strings.ReplaceAll(template, groupPlaceholder, groupName))}
+}
+
+// headerComment turns the text of a group header into a line comment.
+func headerComment(text string) string {
+	if strings.HasPrefix(text, "//") {
+		return text
+	}
+	return "// " + text
+}
@@ -1,12 +1,150 @@
 package main
 
 import (
+	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-var commentRegex = regexp.MustCompile(`(//.*)|(/\*.*\*/)`)
+var (
+	commentRegex      = regexp.MustCompile(`(//.*)|(?s:/\*.*?\*/)`)
+	blockCommentRegex = regexp.MustCompile(`(?s:/\*.*?\*/)`)
+	importPathRegex   = regexp.MustCompile(`"[^"]*"`)
+
+	// pathClasses caches the class and group name of import paths, keyed by the -local prefixes and the path,
+	// when cacheClasses is set. This is done by the serve command, which classifies the same paths again and
+	// again.
+	cacheClasses bool
+	pathClasses  sync.Map
+)
+
+type pathClass struct {
+	class     importClass
+	groupName string
+	rule      string
+}
+
+// cgoImportPath is the pseudo-package which enables cgo.
+const cgoImportPath = "C"
+
+// importClass identifies which kind of group an import belongs to.
+type importClass int
+
+const (
+	standardClass importClass = iota
+	externalClass
+	localClass
+	dotClass
+	blankClass
+)
+
+// classifyImport returns the class of an import line, for external imports the name of its group, and the
+// rule which put it there, as reported by the explain command. Local imports are only classified separately
+// when -local is given, and dot and blank imports only when -dot-group and -blank-group are given.
+func classifyImport(line string, opts *options) (class importClass, groupName, rule string) {
+	switch importAlias(line) {
+	case ".":
+		if opts.dotGroup {
+			return dotClass, "", "dot import, with -dot-group"
+		}
+	case "_":
+		if opts.blankGroup {
+			return blankClass, "", "blank import, with -blank-group"
+		}
+	}
+	path := importPath(line)
+	if !cacheClasses {
+		return classifyPath(path, opts)
+	}
+	key := opts.local + "\n" + path
+	if cached, ok := pathClasses.Load(key); ok {
+		c := cached.(pathClass)
+		return c.class, c.groupName, c.rule
+	}
+	class, groupName, rule = classifyPath(path, opts)
+	pathClasses.Store(key, pathClass{class: class, groupName: groupName, rule: rule})
+	return class, groupName, rule
+}
+
+// classifyPath returns the class of an import path, which is not a dot or blank import, for external
+// imports the name of its group, and the rule which put it there.
+func classifyPath(path string, opts *options) (class importClass, groupName, rule string) {
+	if prefix, ok := localPrefix(path, opts); ok {
+		return localClass, "", fmt.Sprintf("starts with the -local prefix %q", prefix)
+	}
+	if parts, ok := externalGroupParts(path); ok {
+		quoted := make([]string, len(parts))
+		for i, part := range parts {
+			quoted[i] = strconv.Quote(part)
+		}
+		rule := "starts with a domain, grouped by the first and last labels of the domain and the first path element: "
+		return externalClass, strings.Join(parts, ""), rule + strings.Join(quoted, " + ")
+	}
+	if !strings.Contains(path, ".") {
+		return standardClass, "", "the path contains no dot, so it has no domain"
+	}
+	return standardClass, "", "the path contains a dot but does not start with a domain and organization"
+}
+
+// externalGroup returns the name of the group of path if it is a third-party import path, which starts
+// with a domain.
+func externalGroup(path string) (groupName string, ok bool) {
+	parts, ok := externalGroupParts(path)
+	return strings.Join(parts, ""), ok
+}
+
+// externalGroupParts returns the parts of the name of the group of a third-party import path: the first label
+// of its domain, the last label of its domain with its leading dot, and its first path element, e.g. "oss",
+// ".com" and "go" for oss.indeed.com/go/go-groups.
+func externalGroupParts(path string) (parts []string, ok bool) {
+	matches := externalImport.FindStringSubmatch(`"` + path + `"`)
+	if matches == nil || !strings.ContainsAny(path, ".") {
+		return nil, false
+	}
+	return matches[1:], true
+}
+
+// localPrefix returns the first of the -local prefixes which path begins with.
+func localPrefix(path string, opts *options) (string, bool) {
+	for _, prefix := range strings.Split(opts.local, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(path, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// groupDisplayName returns the domain and organization of an external import, e.g. github.com/gorilla.
+func groupDisplayName(line string) string {
+	elements := strings.SplitN(importPath(line), "/", 3)
+	if len(elements) > 2 {
+		elements = elements[:2]
+	}
+	return strings.Join(elements, "/")
+}
+
+// importAlias returns the name an import line is imported as, or an empty string if it has none.
+func importAlias(line string) string {
+	s := strings.TrimSpace(commentRegex.ReplaceAllString(line, ""))
+	if i := strings.IndexAny(s, " \t"); i > 0 && !strings.HasPrefix(s, `"`) {
+		return s[:i]
+	}
+	return ""
+}
+
+// importPath returns the unquoted package path of an import line.
+func importPath(line string) string {
+	s := commentRegex.ReplaceAllString(line, "")
+	return strings.Trim(importPathRegex.FindString(s), `"`)
+}
+
+// importComment returns the comments on an import line.
+func importComment(line string) string {
+	return strings.Join(commentRegex.FindAllString(line, -1), " ")
+}
 
 // Imports represents the list of imports in a given go file.
 // This helper encapsulates the logic for sorting imports based on go-groups.
@@ -22,16 +160,14 @@ func (s Imports) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+// Less orders imports by path. Ties are broken by alias and then by comment, so that sorting is stable
+// even when the same path is imported more than once.
 func (s Imports) Less(i, j int) bool {
-	s1 := strings.TrimSpace(s[i].line)
-	s1 = commentRegex.ReplaceAllString(s1, "")
-	if strings.ContainsAny(s1, " ") {
-		s1 = strings.Join(strings.Split(s1, " ")[1:], " ")
+	if p1, p2 := importPath(s[i].line), importPath(s[j].line); p1 != p2 {
+		return p1 < p2
 	}
-	s2 := strings.TrimSpace(s[j].line)
-	s2 = commentRegex.ReplaceAllString(s2, "")
-	if strings.ContainsAny(s2, " ") {
-		s2 = strings.Join(strings.Split(s2, " ")[1:], " ")
+	if a1, a2 := importAlias(s[i].line), importAlias(s[j].line); a1 != a2 {
+		return a1 < a2
 	}
-	return strings.Compare(s1, s2) < 0
+	return importComment(s[i].line) < importComment(s[j].line)
 }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const initCmd = "init"
+
+var moduleRegex = regexp.MustCompile(`^module\s+("[^"]+"|\S+)`)
+
+// sectionKind describes the imports of a section.
+type sectionKind int
+
+const (
+	mixedSection sectionKind = iota
+	standardSection
+	externalSection
+	blankSection
+	dotSection
+)
+
+// importSection is a run of imports separated from the other imports of its block by blank lines.
+type importSection struct {
+	// comment is the text of the comment above the first import of the section, if any.
+	comment string
+	specs   []string
+}
+
+// conventions counts how the import blocks of a project are grouped, see inferConventions.
+type conventions struct {
+	module string
+	files  []string
+	blocks int
+
+	// localVotes counts the blocks where a prefix is grouped last although it would not be by default,
+	// localAgainst the blocks where it is followed by another third-party group.
+	localVotes    map[string]int
+	localAgainst  map[string]int
+	localComments map[string]map[string]int
+
+	blankImports, blankGrouped int
+	dotImports, dotGrouped     int
+	blankComments, dotComments map[string]int
+
+	headerSections, headedSections int
+	stdComments, externalTemplates map[string]int
+}
+
+// initMain runs the init command, which infers the conventions of the import blocks below a directory and
+// writes them as a configuration.
+func initMain(args []string) int {
+	fs := flag.NewFlagSet(initCmd, flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing "+configName)
+	dryRun := fs.Bool("n", false, "print the inferred configuration instead of writing it")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] [dir]\n", os.Args[0], initCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitBadFlags
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	filename := filepath.Join(dir, configName)
+	if _, err := os.Stat(filename); err == nil && !*force && !*dryRun {
+		_, _ = fmt.Fprintln(os.Stderr, "error: "+filename+" already exists, use -force to overwrite it")
+		return exitBadFlags
+	}
+
+	c, err := inferConventions(dir)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "cannot infer conventions: "+err.Error())
+		return exitInternalError
+	}
+	entries := c.config()
+	header := []string{
+		fmt.Sprintf("Inferred by go-groups %s from %d files with %d import blocks.", initCmd, len(c.files), c.blocks),
+		"Each line sets the default of a flag, which the command line overrides.",
+	}
+	config := formatConfig(header, entries)
+	_, _ = os.Stdout.Write(config)
+
+	opts := flagOptions()
+	if err := opts.apply(filename, entries); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		return exitInternalError
+	}
+	changed, failed := countChanges(c.files, opts)
+	fmt.Printf("%d of %d files would change\n", changed, len(c.files))
+	if failed > 0 {
+		fmt.Printf("%d files could not be processed\n", failed)
+	}
+	if *dryRun {
+		return 0
+	}
+	if err := ioutil.WriteFile(filename, config, 0644); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "cannot write configuration: "+err.Error())
+		return exitInternalError
+	}
+	fmt.Println("wrote " + filename)
+	return 0
+}
+
+// inferConventions scans the Go files below dir. Generated code, files excluded by build constraints, and
+// hidden, vendor and testdata directories are skipped, since they do not follow the project's conventions.
+func inferConventions(dir string) (*conventions, error) {
+	c := &conventions{
+		localVotes:        make(map[string]int),
+		localAgainst:      make(map[string]int),
+		localComments:     make(map[string]map[string]int),
+		blankComments:     make(map[string]int),
+		dotComments:       make(map[string]int),
+		stdComments:       make(map[string]int),
+		externalTemplates: make(map[string]int),
+	}
+	c.module = modulePath(dir)
+	opts := flagOptions()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && path != dir && (!isWatchedDir(path, false) || fi.Name() == "vendor" || fi.Name() == "testdata") {
+			return filepath.SkipDir
+		}
+		if !isGoFile(fi) {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		src, _ = detectLineEndings(src)
+		if isGeneratedFile(path, src, opts) || isBuildExcluded(path, src, opts) {
+			return nil
+		}
+		c.files = append(c.files, path)
+		for _, sections := range importSections(src) {
+			c.addBlock(sections)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// modulePath returns the path of the module containing dir, or an empty string if there is none.
+func modulePath(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				if matches := moduleRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text())); matches != nil {
+					if path, err := strconv.Unquote(matches[1]); err == nil {
+						return path
+					}
+					return matches[1]
+				}
+			}
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// importSections returns the sections of every import block of src.
+func importSections(src []byte) [][]importSection {
+	lines := make([]string, 0, 128)
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	blocks := make([][]importSection, 0, 1)
+	var sections []importSection
+	var section importSection
+	endSection := func() {
+		if len(section.specs) > 0 {
+			sections = append(sections, section)
+		}
+		section = importSection{}
+	}
+	for n, kind := range classifyLines(lines) {
+		line := strings.TrimSpace(lines[n])
+		switch {
+		case kind == importStartLine:
+			sections, section = make([]importSection, 0), importSection{}
+		case kind == importEndLine:
+			endSection()
+			blocks = append(blocks, sections)
+			sections = nil
+		case sections == nil:
+			continue
+		case kind == importSpecLine:
+			section.specs = append(section.specs, line)
+		case line == "":
+			endSection()
+		case strings.HasPrefix(line, "//") && len(section.specs) == 0 && section.comment == "":
+			section.comment = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		}
+	}
+	return blocks
+}
+
+// kind returns the kind of the imports of s.
+func (s importSection) kind() sectionKind {
+	kind := mixedSection
+	for i, spec := range s.specs {
+		var specKind sectionKind
+		switch alias, path := importAlias(spec), importPath(spec); {
+		case alias == "_":
+			specKind = blankSection
+		case alias == ".":
+			specKind = dotSection
+		case path == cgoImportPath:
+			return mixedSection
+		default:
+			if _, ok := externalGroup(path); ok {
+				specKind = externalSection
+			} else {
+				specKind = standardSection
+			}
+		}
+		if i > 0 && specKind != kind {
+			return mixedSection
+		}
+		kind = specKind
+	}
+	return kind
+}
+
+// prefix returns the common prefix of the imports of a third-party section which could be local: the
+// module path, or else their shared domain and organization.
+func (s importSection) prefix(module string) string {
+	prefix := ""
+	for i, spec := range s.specs {
+		path := importPath(spec)
+		name := groupDisplayName(spec)
+		switch {
+		case module != "" && (path == module || strings.HasPrefix(path, module+"/")) && (i == 0 || prefix == module):
+			prefix = module
+		case i == 0 || prefix == name:
+			prefix = name
+		default:
+			return ""
+		}
+	}
+	return prefix
+}
+
+func (c *conventions) addBlock(sections []importSection) {
+	c.blocks++
+
+	// blank and dot imports are grouped if they are in sections of their own at the end of the block
+	trailing := len(sections)
+	for trailing > 0 && (sections[trailing-1].kind() == blankSection || sections[trailing-1].kind() == dotSection) {
+		trailing--
+	}
+	for i, section := range sections {
+		for _, spec := range section.specs {
+			switch importAlias(spec) {
+			case "_":
+				c.blankImports++
+				if i >= trailing {
+					c.blankGrouped++
+				}
+			case ".":
+				c.dotImports++
+				if i >= trailing {
+					c.dotGrouped++
+				}
+			}
+		}
+		if i >= trailing && section.comment != "" {
+			if section.kind() == blankSection {
+				c.blankComments[section.comment]++
+			} else {
+				c.dotComments[section.comment]++
+			}
+		}
+	}
+
+	external := make([]importSection, 0, len(sections))
+	for _, section := range sections[:trailing] {
+		if section.kind() == externalSection {
+			external = append(external, section)
+		}
+	}
+	local := -1
+	for i, section := range external {
+		prefix := section.prefix(c.module)
+		if prefix == "" {
+			continue
+		}
+		if i < len(external)-1 {
+			c.localAgainst[prefix]++
+			continue
+		}
+		// the last group is local if it is the module or would not be the last group by default
+		outOfOrder := prefix == c.module
+		key, _ := externalGroup(importPath(section.specs[0]))
+		for _, earlier := range external[:i] {
+			if earlierKey, _ := externalGroup(importPath(earlier.specs[0])); earlierKey > key {
+				outOfOrder = true
+			}
+		}
+		if outOfOrder && len(external) > 1 || prefix == c.module {
+			local = i
+			c.localVotes[prefix]++
+			if c.localComments[prefix] == nil {
+				c.localComments[prefix] = make(map[string]int)
+			}
+			c.localComments[prefix][section.comment]++
+		}
+	}
+
+	// header comments are only expected in blocks with several groups
+	if trailing < 2 {
+		return
+	}
+	for i, section := range sections[:trailing] {
+		switch section.kind() {
+		case standardSection:
+			c.headerSections++
+			if section.comment != "" {
+				c.headedSections++
+				c.stdComments[section.comment]++
+			}
+		case externalSection:
+			c.headerSections++
+			if section.comment != "" {
+				c.headedSections++
+				if local >= 0 && sections[i].specs[0] == external[local].specs[0] {
+					// counted as a local header
+					continue
+				}
+				name := groupDisplayName(section.specs[0])
+				c.externalTemplates[strings.ReplaceAll(section.comment, name, groupPlaceholder)]++
+			}
+		}
+	}
+}
+
+// config returns the configuration matching the conventions, leaving out the flags whose defaults match.
+func (c *conventions) config() []configEntry {
+	entries := make([]configEntry, 0)
+	add := func(name, value string) {
+		if flag.Lookup(name).DefValue != value {
+			entries = append(entries, configEntry{name: name, value: value})
+		}
+	}
+
+	locals := make([]string, 0)
+	for prefix, votes := range c.localVotes {
+		if votes >= 2*c.localAgainst[prefix] {
+			locals = append(locals, prefix)
+		}
+	}
+	sort.Strings(locals)
+	add("local", strings.Join(locals, ","))
+
+	if c.blankImports > 0 && 2*c.blankGrouped > c.blankImports {
+		add("blank-group", "true")
+		add("blank-comment", mostCommon(c.blankComments))
+	}
+	if c.dotImports > 0 && 2*c.dotGrouped > c.dotImports {
+		add("dot-group", "true")
+		add("dot-comment", mostCommon(c.dotComments))
+	}
+
+	if c.headerSections > 0 && 2*c.headedSections > c.headerSections {
+		add("headers", "true")
+		if comment := mostCommon(c.stdComments); comment != "" {
+			add("std-header", comment)
+		}
+		if template := mostCommon(c.externalTemplates); template != "" {
+			add("external-header", template)
+		}
+		localComments := make(map[string]int)
+		for _, prefix := range locals {
+			for comment, n := range c.localComments[prefix] {
+				localComments[comment] += n
+			}
+		}
+		if comment := mostCommon(localComments); comment != "" {
+			add("local-header", comment)
+		}
+	}
+	return entries
+}
+
+// mostCommon returns the most common non-empty key of counts, or an empty string if there is none.
+func mostCommon(counts map[string]int) string {
+	best, bestN := "", 0
+	for key, n := range counts {
+		if key != "" && (n > bestN || n == bestN && key < best) {
+			best, bestN = key, n
+		}
+	}
+	return best
+}
+
+// countChanges returns how many of files regrouping with opts would change, and how many could not be
+// processed. The files are neither written nor listed.
+func countChanges(files []string, opts *options) (changed, failed int) {
+	for _, filename := range files {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			failed++
+			continue
+		}
+		src, _ = detectLineEndings(src)
+		if _, err := checkAliases(src, filename, opts); err != nil {
+			failed++
+			continue
+		}
+		if !*noFormat {
+			if src, err = gofmt(src, filename); err != nil {
+				failed++
+				continue
+			}
+		}
+		if !bytes.Equal(src, regroup(src, opts)) {
+			changed++
+		}
+	}
+	return changed, failed
+}
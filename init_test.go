@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferConventions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.14\n",
+		"a/a.go": "package a\n\nimport (\n\t// Std\n\t\"fmt\"\n\n" +
+			"\t// Vendors: github.com/pkg\n\t\"github.com/pkg/errors\"\n\n" +
+			"\t// Internal\n\t\"example.com/app/b\"\n\t\"example.com/app/c\"\n\n" +
+			"\t// side effects\n\t_ \"github.com/lib/pq\"\n)\n",
+		"b/b.go": "package b\n\nimport (\n\t// Std\n\t\"strings\"\n\n" +
+			"\t// Vendors: go.uber.org/zap\n\t\"go.uber.org/zap\"\n\n" +
+			"\t// Vendors: github.com/zeta\n\t\"github.com/zeta/z\"\n\n" +
+			"\t// Internal\n\t\"corp.example/lib/x\"\n)\n",
+		"b/gen.go":      "// Code generated by foo. DO NOT EDIT.\n\npackage b\n\nimport (\n\t\"strings\"\n\t_ \"github.com/lib/pq\"\n)\n",
+		"vendor/v/v.go": "package v\n\nimport (\n\t\"strings\"\n\t_ \"github.com/lib/pq\"\n)\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	c, err := inferConventions(dir)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/app", c.module)
+	require.Len(t, c.files, 2)
+	require.Equal(t, 2, c.blocks)
+	require.Equal(t, []configEntry{
+		{name: "local", value: "corp.example/lib,example.com/app"},
+		{name: "blank-group", value: "true"},
+		{name: "blank-comment", value: "side effects"},
+		{name: "headers", value: "true"},
+		{name: "std-header", value: "Std"},
+		{name: "external-header", value: "Vendors: {group}"},
+		{name: "local-header", value: "Internal"},
+	}, c.config())
+}
+
+func TestCountChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"grouped.go":   "package a\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/b\"\n)\n",
+		"ungrouped.go": "package a\n\nimport (\n\t\"example.com/app/b\"\n\t\"fmt\"\n)\n",
+		"invalid.go":   "package a\n\nimport (\n\t\"fmt\"\n",
+	}
+	paths := make([]string, 0, len(files))
+	for name, content := range files {
+		paths = append(paths, filepath.Join(dir, name))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	opts := flagOptions()
+	require.NoError(t, opts.apply(configName, []configEntry{{name: "local", value: "example.com/app"}}))
+	changed, failed := countChanges(paths, opts)
+	require.Equal(t, 1, changed)
+	require.Equal(t, 1, failed)
+
+	// the flags are left alone and the files unchanged
+	require.Empty(t, *local)
+	require.False(t, *list)
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	}
+}
+
+func TestImportSections(t *testing.T) {
+	src := "package a\n\nimport (\n\t// Standard library\n\t\"fmt\"\n\t\"os\"\n\n\t_ \"github.com/lib/pq\"\n)\n\nimport (\n\t\"io\"\n)\n"
+	blocks := importSections([]byte(src))
+	require.Equal(t, [][]importSection{
+		{
+			{comment: "Standard library", specs: []string{`"fmt"`, `"os"`}},
+			{specs: []string{`_ "github.com/lib/pq"`}},
+		},
+		{{specs: []string{`"io"`}}},
+	}, blocks)
+	require.Equal(t, standardSection, blocks[0][0].kind())
+	require.Equal(t, blankSection, blocks[0][1].kind())
+}
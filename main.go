@@ -3,25 +3,48 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
+	"sync/atomic"
 )
 
 const (
-	exitBadFlags      = 1
-	exitBadStdin      = 2
+	exitBadFlags = 1
+	// exitBadStdin is returned when standard input cannot be read, or -w is used with it.
+	exitBadStdin = 2
+	// exitInternalError is returned when go-groups fails for another reason than the contents of a file.
 	exitInternalError = 3
+	// exitNeedsFormatting is returned when -l, -d or -format report a file which needs formatting.
+	exitNeedsFormatting = 4
+	// exitFileErrors is returned when a file could not be processed.
+	exitFileErrors = 5
 
 	versionStr = "go-groups version 1.1.3 (2020-10-14)"
+
+	// stdinName names standard input in messages when -stdin-filename is not given.
+	stdinName = "<standard input>"
+)
+
+var (
+	// changedFiles and failedFiles count the files which need formatting and the files which could not be
+	// processed, which determine the exit code.
+	changedFiles int32
+	failedFiles  int32
 )
 
 var (
-	importStartRegex = regexp.MustCompile(`^\s*import\s*\(\s*$`)
-	importEndRegex   = regexp.MustCompile(`^\s*\)\s*$`)
+	// the start and end of an import block may be followed by a comment, which is captured.
+	importStartRegex = regexp.MustCompile(`^\s*import\s*\(\s*(//.*|/\*.*\*/)?\s*$`)
+	importEndRegex   = regexp.MustCompile(`^\s*\)\s*(//.*|/\*.*\*/)?\s*$`)
 
 	// any whitespace + any unicode_letter_or_underscore + any unicode_letter_or_underscore_or_unicode number + any whitespace + quote + any + quote + any.
 	groupedImportRegex = regexp.MustCompile(`^\s*[\p{L}_\\.]*[\s*[\p{L}_\p{N}]*\s*".*".*$`)
@@ -34,50 +57,266 @@ var (
 	doDiff   = flag.Bool("d", false, "display diffs instead of rewriting files")
 	version  = flag.Bool("v", false, "display the version of go-groups")
 	noFormat = flag.Bool("f", false, "disables the automatic gofmt style fixes")
-	genCode  = flag.Bool("g", false, "include generated code in analysis")
+	genCode  = flag.Bool("g", false, "include generated code in analysis, same as -generated=include")
+	format   = flag.String("format", "", "report import blocks needing regrouping as CI annotations instead of rewriting files: github or gitlab")
+
+	generated        = flag.String("generated", string(generatedSkip), "how to handle generated code: skip, include or only")
+	generatedMarkers = flag.String("generated-markers", "", "comma-separated texts which mark a file as generated when found in a comment before its package clause")
+	generatedFiles   = flag.String("generated-files", "", "comma-separated file name patterns of generated files, e.g. \"*.pb.go,zz_generated.*\"")
+
+	buildTags   = flag.String("tags", "", "comma-separated build tags; with -tags, -goos or -goarch, files excluded from that build configuration are skipped")
+	goos        = flag.String("goos", "", "operating system of the build configuration, see -tags")
+	goarch      = flag.String("goarch", "", "architecture of the build configuration, see -tags")
+	skipIgnored = flag.Bool("skip-ignored", false, "skip files which are only built with the ignore tag, such as //go:build ignore")
+
+	blankGroup   = flag.Bool("blank-group", false, "place blank (side-effect) imports in their own group at the end of the import block")
+	blankComment = flag.String("blank-comment", "", "comment placed above the blank import group, e.g. \"side effects\"")
+	dotGroup     = flag.Bool("dot-group", false, "place dot imports in their own group at the end of the import block")
+	dotComment   = flag.String("dot-comment", "", "comment placed above the dot import group")
+
+	local          = flag.String("local", "", "put imports beginning with this string after third-party packages; comma-separated list")
+	headers        = flag.Bool("headers", false, "place a header comment above each import group")
+	stdHeader      = flag.String("std-header", "Standard library", "header template of the standard library group")
+	externalHeader = flag.String("external-header", "Third party: {group}", "header template of third-party groups, {group} is replaced by the group's domain and organization")
+	localHeader    = flag.String("local-header", "Local", "header template of the local group")
+
+	inPlace      = flag.Bool("in-place", false, "with -w, overwrite files in place instead of atomically replacing them")
+	keepMtime    = flag.Bool("keep-mtime", false, "with -w, preserve the modification time of rewritten files")
+	backupSuffix = flag.String("backup-suffix", "", "with -w, keep the original of every rewritten file, named by appending this suffix")
+	backupDir    = flag.String("backup-dir", "", "with -w, keep the original of every rewritten file in this directory, mirroring the processed paths")
+	normalizeLF  = flag.Bool("lf", false, "normalize line endings to LF instead of keeping the line endings of the file")
+	verifyStable = flag.Bool("verify-stable", false, "fail if formatting the output a second time would change it")
+	dropComments = flag.Bool("drop-comments", false, "drop comments separated from the following import by a blank line instead of keeping them at the top of the import block")
+
+	filesFrom     = flag.String("files-from", "", "read the paths to process from this file, or from standard input if -, one per line")
+	nulSeparated  = flag.Bool("0", false, "with -files-from, paths are separated by NUL characters; with -l, print file names terminated by NUL characters")
+	stdinFilename = flag.String("stdin-filename", "", "the path of the file read from standard input, used in error messages, to find its .go-groups configuration and to apply the rules for excluded and generated files and build constraints; the file does not need to exist")
+	stdinBatch    = flag.Bool("stdin-batch", false, "read JSON records with a filename and content from standard input, one per line, and write the regrouped records to standard output")
+	remote        = flag.String("remote", "", "format standard input with the daemon listening on this Unix socket, or in-process if none is running")
 )
 
 func main() {
 	flag.Usage = usage
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case resolveConflictsCmd:
+			os.Exit(resolveConflictsMain(os.Args[2:]))
+		case restoreCmd:
+			os.Exit(restoreMain(os.Args[2:]))
+		case watchCmd:
+			os.Exit(watchMain(os.Args[2:]))
+		case serveCmd:
+			os.Exit(serveMain(os.Args[2:]))
+		case explainCmd:
+			os.Exit(explainMain(os.Args[2:]))
+		case initCmd:
+			os.Exit(initMain(os.Args[2:]))
+		}
+	}
 	flag.Parse()
+	if err := applyConfig(flag.CommandLine); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		os.Exit(exitBadFlags)
+	}
+	run(flag.Args())
+}
+
+// readFileList reads the paths listed in the file name, or standard input if name is -, separated by
+// newlines or with -0 by NUL characters. Empty entries are ignored.
+func readFileList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if *nulSeparated {
+		sep = "\x00"
+	}
+	files := make([]string, 0)
+	for _, file := range strings.Split(string(data), sep) {
+		if !*nulSeparated {
+			file = strings.TrimSuffix(file, "\r")
+		}
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// processStdin processes standard input, named by -stdin-filename if given.
+func processStdin() {
+	if *stdinBatch {
+		if !printSource() {
+			_, _ = fmt.Fprintln(os.Stderr, "error: cannot use -stdin-batch with -l, -d or -format")
+			os.Exit(exitBadFlags)
+		}
+		if err := formatRequests(os.Stdin, os.Stdout); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to process stdin: "+err.Error())
+			os.Exit(exitBadStdin)
+		}
+		return
+	}
+
+	filename := stdinName
+	if *stdinFilename != "" {
+		filename = *stdinFilename
+		if isExcluded(filename) {
+			if printSource() {
+				_, _ = io.Copy(os.Stdout, os.Stdin)
+			}
+			return
+		}
+	}
+	in := io.Reader(os.Stdin)
+	if *remote != "" && printSource() {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to read stdin: "+err.Error())
+			os.Exit(exitBadStdin)
+		}
+		// the daemon finds the configuration from the working directory of the client
+		wd, err := os.Getwd()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to process stdin: "+err.Error())
+			os.Exit(exitInternalError)
+		}
+		resp, err := remoteFormat(*remote, formatRequest{Filename: *stdinFilename, Content: string(src), Dir: wd})
+		switch {
+		case err == nil:
+			_, _ = os.Stdout.WriteString(resp.Content)
+			return
+		case resp.Error != "":
+			_, _ = fmt.Fprintln(os.Stderr, "failed to parse stdin: "+resp.Error)
+			os.Exit(exitFileErrors)
+		}
+		// the daemon is not running or failed, format in-process
+		in = bytes.NewReader(src)
+	}
+	opts, err := optionsFor(filename)
+	if err == nil {
+		err = processFile(filename, in, os.Stdout, !*noFormat, opts)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to parse stdin: "+err.Error())
+		os.Exit(exitFileErrors)
+	}
+}
 
+// run processes the given paths, or standard input if there are none, according to the parsed flags.
+func run(args []string) {
 	if *version {
 		fmt.Println(versionStr)
 		os.Exit(0)
 	}
 
-	// stdin invocation
-	if flag.NArg() == 0 {
+	if !isValidFormat(*format) {
+		_, _ = fmt.Fprintln(os.Stderr, "error: unknown -format "+*format+", expected github or gitlab")
+		os.Exit(exitBadFlags)
+	}
+	if !isValidGeneratedPolicy(*generated) {
+		_, _ = fmt.Fprintln(os.Stderr, "error: unknown -generated "+*generated+", expected skip, include or only")
+		os.Exit(exitBadFlags)
+	}
+
+	if *remote != "" {
+		if name := remoteIgnoredFlag(); name != "" {
+			_, _ = fmt.Fprintln(os.Stderr, "error: cannot use -remote with -"+name+", the daemon regroups with the flags it was started with")
+			os.Exit(exitBadFlags)
+		}
+	}
+
+	if *filesFrom != "" {
+		files, err := readFileList(*filesFrom)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to read -files-from: "+err.Error())
+			os.Exit(exitBadFlags)
+		}
+		args = append(args, files...)
+	}
+
+	processArgs(args)
+
+	if *format == formatGitLab {
+		if err := writeCodeQualityReport(os.Stdout); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to write code quality report: "+err.Error())
+			os.Exit(exitInternalError)
+		}
+	}
+
+	if code := exitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// exitCode returns the exit code of a run: exitFileErrors if a file could not be processed, or
+// exitNeedsFormatting if a file needs formatting and it was only reported by -l, -d or -format.
+func exitCode() int {
+	switch {
+	case atomic.LoadInt32(&failedFiles) > 0:
+		return exitFileErrors
+	case atomic.LoadInt32(&changedFiles) > 0 && (*list || *doDiff || *format != "") && !*write:
+		return exitNeedsFormatting
+	}
+	return 0
+}
+
+func processArgs(args []string) {
+	// stdin invocation, unless the paths were read from -files-from
+	if len(args) == 0 && *filesFrom == "" {
 		if *write {
 			_, _ = fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
 			os.Exit(exitBadStdin)
 		}
-		if err := processFile("<standard input>", os.Stdin, os.Stdout, !*noFormat, *genCode); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "failed to parse stdin: "+err.Error())
-			os.Exit(exitBadFlags)
-		}
+		processStdin()
 		return
 	}
 
-	for i := 0; i < flag.NArg(); i++ {
-		path := flag.Arg(i)
+	handleSignals()
+	for i, path := range args {
+		if isInterrupted() {
+			reportInterrupted(args[i:], false)
+			os.Exit(exitInterrupted)
+		}
 		switch dir, err := os.Stat(path); {
 		case err != nil:
-			_, _ = fmt.Fprintln(os.Stderr, "no files matching '"+path+"': "+err.Error())
-			os.Exit(exitBadFlags)
+			reportError(errors.New("no files matching '" + path + "': " + err.Error()))
 		case dir.IsDir():
-			if err := walkDir(path); err != nil {
+			if err := walkDir(path); errors.Is(err, errInterrupted) {
+				reportInterrupted(args[i:], true)
+				os.Exit(exitInterrupted)
+			} else if err != nil {
 				_, _ = fmt.Fprintln(os.Stderr, "failed processing path "+path+": "+err.Error())
 				os.Exit(exitInternalError)
 			}
 		default:
-			_ = processFile(path, nil, os.Stdout, !*noFormat, *genCode)
+			if err := processPath(path, os.Stdout); err != nil {
+				reportError(err)
+			}
+			atomic.AddInt32(&processedFiles, 1)
 		}
 	}
+	if isInterrupted() {
+		reportInterrupted(nil, false)
+		os.Exit(exitInterrupted)
+	}
 }
 
 func usage() {
 	_, _ = fmt.Fprintf(os.Stderr, "usage: %s [flags] [path ...]\n", os.Args[0])
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] [path ...]\n", os.Args[0], resolveConflictsCmd)
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] [path ...]\n", os.Args[0], restoreCmd)
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] [path ...]\n", os.Args[0], watchCmd)
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] -socket path\n", os.Args[0], serveCmd)
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] file|import-path ...\n", os.Args[0], explainCmd)
+	_, _ = fmt.Fprintf(os.Stderr, "       %s %s [flags] [dir]\n", os.Args[0], initCmd)
 	flag.PrintDefaults()
 }
 
@@ -85,88 +324,187 @@ type importGroup struct {
 	lineStart int
 	lineEnd   int
 
+	// startComment and endComment are the comments following "import (" and the closing paren.
+	startComment string
+	endComment   string
+
 	lines []importLine
+
+	// comments are the standalone comments of the block, which are not attached to any import.
+	comments []string
+	// trailing is the content between the last import and the end of the block, which stays at the end.
+	trailing []string
+
+	// cgo is the import of "C" together with its preamble, which must stay in its own declaration.
+	cgo *importLine
 }
 
 type importLine struct {
 	line         string
+	lineNum      int
 	contentAbove string
-	contentBelow string
 }
 
-func parse(src []byte) (result []byte, rewritten bool) {
-	groups := make([]importGroup, 0, 1)
-	contents := make(map[int]string, 128)
+// parse regroups the import blocks of src. Paths imported under several names must have been checked by
+// checkImportAliases.
+func parse(src []byte, opts *options) (result []byte, rewritten bool) {
+	contents, n, groups := parseImportGroups(src, opts)
+
+	// nothing to do
+	if len(groups) == 0 {
+		return []byte{}, false
+	}
+
+	for i, group := range groups {
+		groups[i] = regroupImportGroups(group, opts)
+	}
+
+	fileBytes := fixupFile(contents, n, groups, opts)
+
+	return fileBytes, true
+}
+
+// lineKind classifies the lines of a source file for parsing its import blocks.
+type lineKind int
+
+const (
+	contentLine lineKind = iota
+	importStartLine
+	importEndLine
+	importSpecLine
+	// specCommentLine continues a block comment which was opened on an import spec line.
+	specCommentLine
+)
+
+// classifyLines determines the kind of every line. Block comments inside import blocks are tracked,
+// so that commented out imports or parens are not mistaken for import specs or the end of the block.
+func classifyLines(lines []string) []lineKind {
+	kinds := make([]lineKind, len(lines))
+	insideImports, inComment, specComment := false, false, false
+	for n, line := range lines {
+		switch {
+		case !insideImports:
+			if importStartRegex.MatchString(line) {
+				kinds[n] = importStartLine
+				insideImports = true
+			}
+		case inComment:
+			if specComment {
+				kinds[n] = specCommentLine
+			}
+			if i := strings.Index(line, "*/"); i >= 0 {
+				inComment = opensBlockComment(line[i+2:])
+			}
+		case importEndRegex.MatchString(line):
+			kinds[n] = importEndLine
+			insideImports = false
+		default:
+			if isImportSpec(line) {
+				kinds[n] = importSpecLine
+			}
+			inComment = opensBlockComment(line)
+			specComment = inComment && kinds[n] == importSpecLine
+		}
+	}
+	return kinds
+}
+
+// isImportSpec reports whether line is an import spec, which may be preceded by block comments.
+func isImportSpec(line string) bool {
+	return groupedImportRegex.MatchString(blockCommentRegex.ReplaceAllString(line, ""))
+}
+
+// opensBlockComment reports whether line opens a block comment which is not closed on the same line.
+func opensBlockComment(line string) bool {
+	inComment := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case inComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				inComment = false
+				i++
+			}
+		case line[i] == '"' || line[i] == '`':
+			end := strings.IndexByte(line[i+1:], line[i])
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		case strings.HasPrefix(line[i:], "//"):
+			return false
+		case strings.HasPrefix(line[i:], "/*"):
+			inComment = true
+			i++
+		}
+	}
+	return inComment
+}
+
+// parseImportGroups scans src for grouped import blocks. It returns every line outside of the
+// import blocks keyed by line number, the total number of lines and the import blocks themselves.
+func parseImportGroups(src []byte, opts *options) (contents map[int]string, numLines int, groups []importGroup) {
+	groups = make([]importGroup, 0, 1)
+	contents = make(map[int]string, 128)
 
 	scanner := bufio.NewScanner(bytes.NewReader(src))
 	lines := make([]string, 0, 128)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
+	kinds := classifyLines(lines)
 
+	headerPatterns := groupHeaders(opts)
 	insideImports := false
-	var n int
 	var group importGroup
-	scanner = bufio.NewScanner(bytes.NewReader(src))
-	for n = 0; scanner.Scan(); n++ {
-		line := scanner.Text()
-		if insideImports {
-			if importEndRegex.MatchString(line) {
-				insideImports = false
-				group.lineEnd = n
-				groups = append(groups, group)
-			} else if groupedImportRegex.MatchString(line) {
-				importLine := importLine{
-					line: line,
-				}
-				var above, below int
-				for above = n - 1; above > 0; above-- {
-					if groupedImportRegex.MatchString(lines[above]) || importStartRegex.MatchString(lines[above]) {
-						above++
-						break
-					}
-				}
-				for below = n + 1; below < len(lines); below++ {
-					if importEndRegex.MatchString(lines[below]) {
-						below--
-						break
-					}
-					// if we hit an import beneath us, assume it owns the non-import content above itself, not us
-					if groupedImportRegex.MatchString(lines[below]) {
-						below = n
-						break
-					}
-				}
-				if above != n {
-					importLine.contentAbove = strings.Join(filterNewlines(lines[above:n]), "\n")
-				}
-				if below != n {
-					importLine.contentBelow = strings.Join(filterNewlines(lines[n+1:below+1]), "\n")
-				}
-				group.lines = append(group.lines, importLine)
-			}
-		} else if importStartRegex.MatchString(line) {
+	var lastSpecEnd int
+	for n, line := range lines {
+		switch kinds[n] {
+		case importStartLine:
 			insideImports = true
+			lastSpecEnd = n
 			group = importGroup{
-				lineStart: n,
+				lineStart:    n,
+				startComment: importStartRegex.FindStringSubmatch(line)[1],
+			}
+		case importEndLine:
+			insideImports = false
+			group.trailing = filterNewlines(lines[lastSpecEnd+1 : n])
+			group.lineEnd = n
+			group.endComment = importEndRegex.FindStringSubmatch(line)[1]
+			groups = append(groups, group)
+		case importSpecLine:
+			// a block comment opened on the import line belongs to it, even if it spans several lines
+			end := n
+			for end+1 < len(lines) && kinds[end+1] == specCommentLine {
+				end++
+			}
+			importLine := importLine{
+				line:    strings.Join(lines[n:end+1], "\n"),
+				lineNum: n,
+			}
+			lastSpecEnd = end
+			var above int
+			for above = n - 1; above > 0; above-- {
+				if kind := kinds[above]; kind == importSpecLine || kind == specCommentLine || kind == importStartLine {
+					above++
+					break
+				}
+			}
+			if above != n {
+				standalone, doc := splitStandaloneComments(lines[above:n])
+				group.comments = append(group.comments, filterHeaders(headerPatterns, standalone)...)
+				importLine.contentAbove = strings.Join(filterHeaders(headerPatterns, doc), "\n")
+			}
+			group.lines = append(group.lines, importLine)
+		case specCommentLine:
+			// written as part of its import line
+		case contentLine:
+			if !insideImports {
+				contents[n] = line
 			}
-		} else {
-			contents[n] = line
 		}
 	}
-
-	// nothing to do
-	if len(groups) == 0 {
-		return []byte{}, false
-	}
-
-	for i, group := range groups {
-		groups[i] = regroupImportGroups(group)
-	}
-
-	fileBytes := fixupFile(contents, n, groups)
-
-	return fileBytes, true
+	return contents, len(lines), groups
 }
 
 func filterNewlines(lines []string) []string {
@@ -179,7 +517,18 @@ func filterNewlines(lines []string) []string {
 	return filtered
 }
 
-func fixupFile(contents map[int]string, numLines int, groups []importGroup) []byte {
+// splitStandaloneComments splits the content above an import into the comments which are separated
+// from the import by a blank line, and the doc comment directly above the import which moves with it.
+func splitStandaloneComments(lines []string) (standalone, doc []string) {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			return filterNewlines(lines[:i]), lines[i+1:]
+		}
+	}
+	return nil, lines
+}
+
+func fixupFile(contents map[int]string, numLines int, groups []importGroup, opts *options) []byte {
 	buffer := bytes.NewBufferString("")
 	for i := 0; i < numLines; i++ {
 		line, ok := contents[i]
@@ -192,26 +541,11 @@ func fixupFile(contents map[int]string, numLines int, groups []importGroup) []by
 			if group.lineStart != i {
 				continue
 			}
-			buffer.WriteString("import (\n")
-			leadingWhitespace := true
-			for _, importLine := range group.lines {
-				if leadingWhitespace && strings.TrimSpace(importLine.line) == "" {
-					// skip empty leading import lines
-				} else {
-					if importLine.contentAbove != "" {
-						buffer.WriteString(importLine.contentAbove)
-						buffer.WriteString("\n")
-					}
-					buffer.WriteString(importLine.line)
-					buffer.WriteString("\n")
-					if importLine.contentBelow != "" {
-						buffer.WriteString(importLine.contentBelow)
-						buffer.WriteString("\n")
-					}
-					leadingWhitespace = false
-				}
+			writeImportGroup(buffer, group, opts)
+			if group.cgo != nil {
+				buffer.WriteString("\n")
+				writeCgoImport(buffer, *group.cgo)
 			}
-			buffer.WriteString(")\n")
 			i = group.lineEnd
 			break
 		}
@@ -219,40 +553,269 @@ func fixupFile(contents map[int]string, numLines int, groups []importGroup) []by
 	return buffer.Bytes()
 }
 
+// writeImportGroup writes the import block of group, from the opening "import (" through the closing paren.
+func writeImportGroup(buffer *bytes.Buffer, group importGroup, opts *options) {
+	buffer.WriteString("import (")
+	if group.startComment != "" {
+		buffer.WriteString(" " + group.startComment)
+	}
+	buffer.WriteString("\n")
+	if len(group.comments) > 0 && !opts.dropComments {
+		for _, comment := range group.comments {
+			buffer.WriteString(comment)
+			buffer.WriteString("\n")
+		}
+		if len(group.lines) > 0 {
+			buffer.WriteString("\n")
+		}
+	}
+	leadingWhitespace := true
+	for _, importLine := range group.lines {
+		if leadingWhitespace && strings.TrimSpace(importLine.line) == "" {
+			// skip empty leading import lines
+		} else {
+			if importLine.contentAbove != "" {
+				buffer.WriteString(importLine.contentAbove)
+				buffer.WriteString("\n")
+			}
+			buffer.WriteString(importLine.line)
+			buffer.WriteString("\n")
+			leadingWhitespace = false
+		}
+	}
+	for _, line := range group.trailing {
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+	}
+	buffer.WriteString(")")
+	if group.endComment != "" {
+		buffer.WriteString(" " + group.endComment)
+	}
+	buffer.WriteString("\n")
+}
+
+// checkImportAliases checks the imports of a path which was already imported under another name. Importing
+// a path under two different aliases is pointless and reported as an error, while importing it both with and
+// without an alias is valid and only reported as a warning. Blank and dot imports are not reported, since
+// they do not name the package. A path imported under the same name in two import blocks is an error too,
+// since duplicates are only removed within a block. The positions of the returned errors only carry line
+// numbers.
+func checkImportAliases(groups []importGroup) (warnings scanner.ErrorList, err error) {
+	var errs scanner.ErrorList
+	named := make(map[string][]importLine)
+	// blocks maps alias and path to the first line of the block which imports them first
+	blocks := make(map[string]int)
+	for _, group := range groups {
+		for _, importLine := range group.lines {
+			path, alias := importPath(importLine.line), importAlias(importLine.line)
+			if alias == "_" || alias == "." {
+				continue
+			}
+			// duplicates are removed within a block, but cannot be moved across blocks
+			key := alias + " " + path
+			if start, ok := blocks[key]; ok && start != group.lineStart {
+				pos := token.Position{Line: importLine.lineNum + 1, Column: 1}
+				errs.Add(pos, fmt.Sprintf("%q imported %s again, already imported in the import block on line %d", path, describeAlias(alias), start+1))
+				continue
+			}
+			blocks[key] = group.lineStart
+			if hasAlias(named[path], alias) {
+				continue
+			}
+			prevs := named[path]
+			named[path] = append(prevs, importLine)
+			if len(prevs) == 0 {
+				continue
+			}
+			prev := prevs[0]
+			for _, p := range prevs {
+				if alias != "" && importAlias(p.line) != "" {
+					prev = p
+					break
+				}
+			}
+			prevAlias := importAlias(prev.line)
+			pos := token.Position{Line: importLine.lineNum + 1, Column: 1}
+			msg := fmt.Sprintf("%q imported %s, but already imported %s on line %d", path, describeAlias(alias), describeAlias(prevAlias), prev.lineNum+1)
+			if alias != "" && prevAlias != "" {
+				errs.Add(pos, msg)
+			} else {
+				warnings.Add(pos, msg)
+			}
+		}
+	}
+	return warnings, errs.Err()
+}
+
+// hasAlias reports whether one of lines imports its path as alias.
+func hasAlias(lines []importLine, alias string) bool {
+	for _, importLine := range lines {
+		if importAlias(importLine.line) == alias {
+			return true
+		}
+	}
+	return false
+}
+
+func describeAlias(alias string) string {
+	if alias == "" {
+		return "without alias"
+	}
+	return "as " + alias
+}
+
+// dedupeImports removes imports which repeat an earlier import of the same path under the same alias.
+// The comments of a removed import are merged into the import which is kept.
+func dedupeImports(lines []importLine) []importLine {
+	deduped := make([]importLine, 0, len(lines))
+	seen := make(map[string]int, len(lines))
+	for _, importLine := range lines {
+		key := importAlias(importLine.line) + " " + importPath(importLine.line)
+		if i, ok := seen[key]; ok {
+			deduped[i] = mergeDuplicateImport(deduped[i], importLine)
+			continue
+		}
+		seen[key] = len(deduped)
+		deduped = append(deduped, importLine)
+	}
+	return deduped
+}
+
+// mergeDuplicateImport merges the comments of dup, which repeats the import kept, into kept. A comment on
+// the line of dup is moved above kept if kept has comments of its own, even if it is the same comment, so
+// that no comment is lost.
+func mergeDuplicateImport(kept, dup importLine) importLine {
+	kept.contentAbove = joinLines(kept.contentAbove, dup.contentAbove)
+	switch comment := importComment(dup.line); {
+	case comment == "":
+	case importComment(kept.line) == "":
+		kept.line = dup.line
+	default:
+		indent := dup.line[:len(dup.line)-len(strings.TrimLeft(dup.line, " \t"))]
+		kept.contentAbove = joinLines(kept.contentAbove, indent+comment)
+	}
+	return kept
+}
+
+func joinLines(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n" + b
+}
+
+// writeCgoImport writes the import of "C" as a single import declaration directly below its preamble.
+func writeCgoImport(buffer *bytes.Buffer, cgo importLine) {
+	writeDedented(buffer, cgo.contentAbove)
+	buffer.WriteString("import " + strings.TrimSpace(cgo.line) + "\n")
+}
+
+// writeDedented writes content which was indented inside an import block at the top level.
+func writeDedented(buffer *bytes.Buffer, content string) {
+	if content == "" {
+		return
+	}
+	for _, line := range strings.Split(content, "\n") {
+		buffer.WriteString(strings.TrimPrefix(line, "\t"))
+		buffer.WriteString("\n")
+	}
+}
+
+// extractCgoImport removes the import of "C" from a group containing other imports, so that
+// it can be written as its own declaration. cgo requires "C" to be imported directly below
+// its preamble, which is the comment above it.
+func extractCgoImport(group importGroup) importGroup {
+	if len(group.lines) < 2 {
+		return group
+	}
+	lines := make([]importLine, 0, len(group.lines))
+	for i, importLine := range group.lines {
+		if group.cgo == nil && importPath(importLine.line) == cgoImportPath {
+			group.cgo = &group.lines[i]
+			continue
+		}
+		lines = append(lines, importLine)
+	}
+	group.lines = lines
+	return group
+}
+
 // regroupImportGroups iterates each line of the import group and sorts the imports
+// exact duplicate imports are removed
 // standard library imports are grouped together and sorted alphabetically
+// the import of "C" is extracted into its own declaration to keep it attached to its cgo preamble
 // each second-level external import is grouped together (e.g github.com/pkg.* is one group)
 // each of these second-level groups is discovered and sorted alphabetically
+// imports matching -local are grouped together after the external imports
+// dot and blank imports are optionally moved to their own groups at the end of the block
+// with -headers each group is preceded by a header comment
 // then each import is matched with their group and the list of lines to be written is built up.
-func regroupImportGroups(group importGroup) importGroup {
+func regroupImportGroups(group importGroup, opts *options) importGroup {
+	group.lines = dedupeImports(group.lines)
+	group = extractCgoImport(group)
 	standardImports := make(Imports, 0, len(group.lines))
+	localImports := make(Imports, 0)
+	dotImports := make(Imports, 0)
+	blankImports := make(Imports, 0)
 
 	sortedKeys := make([]string, 0)
 	groupNames := make(map[string]Imports)
 	for _, importLine := range group.lines {
-		matches := externalImport.FindStringSubmatch(importLine.line)
-
-		if matches != nil && strings.ContainsAny(importLine.line, ".") {
-			groupName := strings.Join(matches[1:], "")
+		switch class, groupName, _ := classifyImport(importLine.line, opts); class {
+		case externalClass:
 			if groupNames[groupName] == nil {
 				groupNames[groupName] = make(Imports, 0, 1)
 				sortedKeys = append(sortedKeys, groupName)
 			}
 			groupNames[groupName] = append(groupNames[groupName], importLine)
-		} else {
+		case localClass:
+			localImports = append(localImports, importLine)
+		case dotClass:
+			dotImports = append(dotImports, importLine)
+		case blankClass:
+			blankImports = append(blankImports, importLine)
+		case standardClass:
 			standardImports = append(standardImports, importLine)
 		}
 	}
-	sort.Sort(standardImports)
+	sort.Stable(standardImports)
 	sort.Strings(sortedKeys)
 
-	group.lines = standardImports
+	group.lines = make([]importLine, 0, len(group.lines))
+	if len(standardImports) > 0 && opts.headers && opts.stdHeader != "" {
+		group.lines = append(group.lines, headerLine(opts.stdHeader, ""))
+	}
+	group.lines = append(group.lines, standardImports...)
 	for _, groupName := range sortedKeys {
 		imports := groupNames[groupName]
-		sort.Sort(imports)
+		sort.Stable(imports)
 
 		group.lines = append(group.lines, importLine{})
+		if opts.headers && opts.externalHeader != "" {
+			group.lines = append(group.lines, headerLine(opts.externalHeader, groupDisplayName(imports[0].line)))
+		}
 		group.lines = append(group.lines, imports...)
 	}
+	localGroupHeader := ""
+	if opts.headers {
+		localGroupHeader = opts.localHeader
+	}
+	group.lines = appendSpecialGroup(group.lines, localImports, localGroupHeader)
+	group.lines = appendSpecialGroup(group.lines, dotImports, opts.dotComment)
+	group.lines = appendSpecialGroup(group.lines, blankImports, opts.blankComment)
 	return group
 }
+
+// appendSpecialGroup sorts imports and appends them as a separate group, preceded by an optional header comment.
+func appendSpecialGroup(lines, imports Imports, header string) Imports {
+	if len(imports) == 0 {
+		return lines
+	}
+	sort.Stable(imports)
+
+	lines = append(lines, importLine{})
+	if header != "" {
+		lines = append(lines, headerLine(header, ""))
+	}
+	return append(lines, imports...)
+}
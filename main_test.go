@@ -2,7 +2,11 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"go/scanner"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -10,7 +14,7 @@ import (
 )
 
 func TestParse_NoImports(t *testing.T) {
-	_, rewritten := parse([]byte(""))
+	_, rewritten := parse([]byte(""), flagOptions())
 	require.False(t, rewritten)
 }
 
@@ -20,7 +24,12 @@ func TestParse(t *testing.T) {
 		ActualFixture   string
 		ExpectedFixture string
 		NoGoFmt         bool
-		GenCode         bool
+		Generated       generatedPolicy
+		BlankGroup      bool
+		DotGroup        bool
+		Headers         bool
+		Local           string
+		DropComments    bool
 	}
 	testcases := []testcase{
 		{
@@ -84,19 +93,138 @@ func TestParse(t *testing.T) {
 			Description:     "go-groups should run over generated code",
 			ActualFixture:   "code_generated.txt",
 			ExpectedFixture: "code_generated_valid.txt",
-			GenCode:         true,
+			Generated:       generatedInclude,
+		},
+		{
+			Description:     "go-groups should only run over generated code",
+			ActualFixture:   "code_generated.txt",
+			ExpectedFixture: "code_generated_valid.txt",
+			Generated:       generatedOnly,
+		},
+		{
+			Description:     "go-groups should not run over regular code when only running over generated code",
+			ActualFixture:   "external_groups_invalid.txt",
+			ExpectedFixture: "external_groups_invalid.txt",
+			Generated:       generatedOnly,
+		},
+		{
+			Description:     "go-groups should ignore generated code markers after the package clause",
+			ActualFixture:   "code_generated_after_package_invalid.txt",
+			ExpectedFixture: "code_generated_after_package.txt",
 		},
 		{
 			Description:     "go-groups order imports with lint comments",
 			ActualFixture:   "import_with_lint_comment.txt",
 			ExpectedFixture: "import_with_lint_comment.txt",
 		},
+		{
+			Description:     "go-groups should keep the cgo import in its own declaration with its preamble",
+			ActualFixture:   "cgo_imports_invalid.txt",
+			ExpectedFixture: "cgo_imports.txt",
+		},
+		{
+			Description:     "go-groups should not modify a separate cgo import declaration",
+			ActualFixture:   "cgo_imports.txt",
+			ExpectedFixture: "cgo_imports.txt",
+		},
+		{
+			Description:     "go-groups should remove duplicate imports",
+			ActualFixture:   "duplicate_imports_invalid.txt",
+			ExpectedFixture: "duplicate_imports.txt",
+			NoGoFmt:         true,
+		},
+		{
+			Description:     "go-groups should group blank and dot imports separately",
+			ActualFixture:   "blank_dot_groups_invalid.txt",
+			ExpectedFixture: "blank_dot_groups.txt",
+			BlankGroup:      true,
+			DotGroup:        true,
+		},
+		{
+			Description:     "go-groups should replace existing blank and dot group comments",
+			ActualFixture:   "blank_dot_groups.txt",
+			ExpectedFixture: "blank_dot_groups.txt",
+			BlankGroup:      true,
+			DotGroup:        true,
+		},
+		{
+			Description:     "go-groups should place local imports last and add group headers",
+			ActualFixture:   "group_headers_invalid.txt",
+			ExpectedFixture: "group_headers.txt",
+			NoGoFmt:         true,
+			Headers:         true,
+			Local:           "oss.indeed.com/go/go-groups",
+		},
+		{
+			Description:     "go-groups should replace existing group headers",
+			ActualFixture:   "group_headers.txt",
+			ExpectedFixture: "group_headers.txt",
+			Headers:         true,
+			Local:           "oss.indeed.com/go/go-groups",
+		},
+		{
+			Description:     "go-groups should keep standalone comments at the top of the import block",
+			ActualFixture:   "standalone_comments_invalid.txt",
+			ExpectedFixture: "standalone_comments.txt",
+		},
+		{
+			Description:     "go-groups should not modify standalone comments at the top of the import block",
+			ActualFixture:   "standalone_comments.txt",
+			ExpectedFixture: "standalone_comments.txt",
+		},
+		{
+			Description:     "go-groups should drop standalone comments",
+			ActualFixture:   "standalone_comments_invalid.txt",
+			ExpectedFixture: "standalone_comments_dropped.txt",
+			DropComments:    true,
+		},
+		{
+			Description:     "go-groups should keep block comments and comments on the import block delimiters",
+			ActualFixture:   "block_comments_invalid.txt",
+			ExpectedFixture: "block_comments.txt",
+			NoGoFmt:         true,
+		},
+		{
+			Description:     "go-groups should not modify sorted imports with block comments",
+			ActualFixture:   "block_comments.txt",
+			ExpectedFixture: "block_comments.txt",
+			NoGoFmt:         true,
+		},
+		{
+			Description:     "go-groups should keep comments below the last import at the end of the import block",
+			ActualFixture:   "trailing_comments_invalid.txt",
+			ExpectedFixture: "trailing_comments.txt",
+			NoGoFmt:         true,
+		},
+		{
+			Description:     "go-groups should keep a path imported both with and without an alias, or as a blank or dot import",
+			ActualFixture:   "duplicate_aliases_invalid.txt",
+			ExpectedFixture: "duplicate_aliases.txt",
+		},
+		{
+			Description:     "go-groups should not modify a path imported both with and without an alias",
+			ActualFixture:   "duplicate_aliases.txt",
+			ExpectedFixture: "duplicate_aliases.txt",
+		},
 	}
+	defer func(blank, dot, hdrs, drop bool, comment, loc string) {
+		*blankGroup, *dotGroup, *headers, *dropComments, *blankComment, *local = blank, dot, hdrs, drop, comment, loc
+	}(*blankGroup, *dotGroup, *headers, *dropComments, *blankComment, *local)
+	*blankComment = "side effects"
+	defer func(v bool) { *verifyStable = v }(*verifyStable)
+	*verifyStable = true
 	var buf bytes.Buffer
 	for _, testcase := range testcases {
 		bytes := testdata(t, testcase.ActualFixture)
 		expected := testdata(t, testcase.ExpectedFixture)
-		err := processFile("", strings.NewReader(string(bytes)), &buf, !testcase.NoGoFmt, testcase.GenCode)
+		*blankGroup, *dotGroup = testcase.BlankGroup, testcase.DotGroup
+		*headers, *local = testcase.Headers, testcase.Local
+		*dropComments = testcase.DropComments
+		opts := flagOptions()
+		if testcase.Generated != "" {
+			opts.generated = testcase.Generated
+		}
+		err := processFile("", strings.NewReader(string(bytes)), &buf, !testcase.NoGoFmt, opts)
 
 		if buf.String() != string(expected) {
 			t.Logf("Input: \n%s\n\nOutput: \n%s\n\nExpected: \n%s\n\n", string(bytes), buf.String(), string(expected))
@@ -107,6 +235,212 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParse_ConflictingAliases(t *testing.T) {
+	src := strings.Join([]string{
+		"package main",
+		"",
+		"import (",
+		"\t\"fmt\"",
+		"\tpkgerrors \"github.com/pkg/errors\"",
+		"\tf \"fmt\"",
+		"\terrs \"github.com/pkg/errors\"",
+		"\tformat \"fmt\"",
+		")",
+		"",
+	}, "\n")
+	var buf bytes.Buffer
+	err := processFile("foo.go", strings.NewReader(src), &buf, false, flagOptions())
+	require.EqualError(t, err, `foo.go:7:1: "github.com/pkg/errors" imported as errs, but already imported as pkgerrors on line 5 (and 1 more errors)`)
+
+	var errs scanner.ErrorList
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 2)
+	require.Equal(t, `foo.go:8:1: "fmt" imported as format, but already imported as f on line 6`, errs[1].Error())
+}
+
+func TestParse_ConflictingAliasesFormatted(t *testing.T) {
+	// gofmt removes the extra blank lines, the errors refer to the lines of the file as it was read
+	src := "package main\n\n\n\nimport (\n\t\"os\"\n\n\n\tf \"fmt\"\n\tg \"fmt\"\n\n\n\t\"net/url\"\n\turlpkg \"net/url\"\n)\n"
+	var buf bytes.Buffer
+	err := processFile("foo.go", strings.NewReader(src), &buf, true, flagOptions())
+	require.EqualError(t, err, `foo.go:10:1: "fmt" imported as g, but already imported as f on line 9`)
+	warnings, err := checkAliases([]byte(src), "foo.go", flagOptions())
+	require.Error(t, err)
+	require.Len(t, warnings, 1)
+	require.Equal(t, `foo.go:14:1: "net/url" imported as urlpkg, but already imported without alias on line 13`, warnings[0].Error())
+}
+
+func TestParse_DuplicateAcrossBlocks(t *testing.T) {
+	src := "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nimport (\n\t_ \"embed\"\n\t\"fmt\"\n)\n\nimport (\n\t_ \"embed\"\n)\n"
+	var buf bytes.Buffer
+	err := processFile("foo.go", strings.NewReader(src), &buf, true, flagOptions())
+	require.EqualError(t, err, `foo.go:10:1: "fmt" imported without alias again, already imported in the import block on line 3`)
+
+	// an import block in a string literal is not an import of the file
+	src = "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nconst test = `package test\n\nimport (\n\t\"fmt\"\n)\n`\n"
+	buf.Reset()
+	require.NoError(t, processFile("foo.go", strings.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, src, buf.String())
+}
+
+func TestParse_EmptyHeaders(t *testing.T) {
+	defer func(v bool) { *verifyStable = v }(*verifyStable)
+	*verifyStable = true
+
+	// an empty header template, as with -std-header "", places no header above its groups
+	opts := flagOptions()
+	opts.headers, opts.stdHeader, opts.externalHeader = true, "", ""
+	opts.local = "oss.indeed.com/go/go-groups"
+	expected := `package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/csrf"
+	// mux routes requests
+	"github.com/gorilla/mux"
+
+	"github.com/pkg/errors"
+
+	// Local
+	"oss.indeed.com/go/go-groups/internal"
+)
+`
+	var buf bytes.Buffer
+	require.NoError(t, processFile("", bytes.NewReader(testdata(t, "group_headers_invalid.txt")), &buf, false, opts))
+	require.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	require.NoError(t, processFile("", strings.NewReader(expected), &buf, true, opts))
+	require.Equal(t, expected, buf.String())
+}
+
+func TestParse_AliasWarnings(t *testing.T) {
+	_, _, groups := parseImportGroups(testdata(t, "duplicate_aliases_invalid.txt"), flagOptions())
+	warnings, err := checkImportAliases(groups)
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	require.Equal(t, `13:1: "net/url" imported as urlpkg, but already imported without alias on line 5`, warnings[0].Error())
+}
+
+func TestParse_LineEndings(t *testing.T) {
+	defer func(l, n bool) { *list, *normalizeLF = l, n }(*list, *normalizeLF)
+
+	src := string(utf8BOM) + "package main\r\n\r\nimport (\r\n\t\"os\"\r\n\t\"fmt\"\r\n)\r\n"
+	expected := string(utf8BOM) + "package main\r\n\r\nimport (\r\n\t\"fmt\"\r\n\t\"os\"\r\n)\r\n"
+	var buf bytes.Buffer
+	require.NoError(t, processFile("", strings.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	require.NoError(t, processFile("", strings.NewReader(expected), &buf, true, flagOptions()))
+	require.Equal(t, expected, buf.String())
+
+	*normalizeLF = true
+	buf.Reset()
+	require.NoError(t, processFile("", strings.NewReader(expected), &buf, true, flagOptions()))
+	require.Equal(t, strings.ReplaceAll(expected, "\r\n", "\n"), buf.String())
+
+	*list = true
+	buf.Reset()
+	require.NoError(t, processFile("foo.go", strings.NewReader(expected), &buf, true, flagOptions()))
+	require.Equal(t, "foo.go\n", buf.String())
+}
+
+func TestParse_MixedLineEndings(t *testing.T) {
+	defer func(l, n bool) { *list, *normalizeLF = l, n }(*list, *normalizeLF)
+
+	valid := testdata(t, "mixed_line_endings.txt")
+	var buf bytes.Buffer
+	require.NoError(t, processFile("", bytes.NewReader(valid), &buf, true, flagOptions()))
+	require.Equal(t, string(valid), buf.String())
+
+	invalid := testdata(t, "mixed_line_endings_invalid.txt")
+	buf.Reset()
+	err := processFile("foo.go", bytes.NewReader(invalid), &buf, true, flagOptions())
+	require.EqualError(t, err, "foo.go: refusing to rewrite a file with mixed line endings, use -lf to normalize them")
+
+	*normalizeLF = true
+	buf.Reset()
+	require.NoError(t, processFile("foo.go", bytes.NewReader(invalid), &buf, true, flagOptions()))
+	require.Equal(t, strings.ReplaceAll(string(valid), "\r\n", "\n"), buf.String())
+
+	*list, *normalizeLF = true, false
+	buf.Reset()
+	require.NoError(t, processFile("foo.go", bytes.NewReader(invalid), &buf, true, flagOptions()))
+	require.Equal(t, "foo.go\n", buf.String())
+}
+
+func TestParse_NoImportBlock(t *testing.T) {
+	src := "package main\n\nimport \"fmt\"\n"
+	var buf bytes.Buffer
+	require.NoError(t, processFile("", strings.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, src, buf.String())
+}
+
+func TestOpensBlockComment(t *testing.T) {
+	require.True(t, opensBlockComment(`"fmt" /* comment`))
+	require.True(t, opensBlockComment(`/* one */ "fmt" /* two`))
+	require.False(t, opensBlockComment(`"fmt" /* comment */`))
+	require.False(t, opensBlockComment(`"fmt" // comment /*`))
+	require.False(t, opensBlockComment(`"example.com/*" // comment`))
+}
+
+func TestReadFileList(t *testing.T) {
+	defer func(n bool) { *nulSeparated = n }(*nulSeparated)
+
+	f, err := ioutil.TempFile("", "go-groups")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	require.NoError(t, f.Close())
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("a.go\r\npkg/b c.go\n\nd.go"), 0600))
+	files, err := readFileList(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.go", "pkg/b c.go", "d.go"}, files)
+
+	*nulSeparated = true
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("a.go\x00pkg/b\nc.go\x00"), 0600))
+	files, err = readFileList(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.go", "pkg/b\nc.go"}, files)
+
+	defer func(l bool) { *list = l }(*list)
+	*list = true
+	var buf bytes.Buffer
+	src := testdata(t, "external_groups_invalid.txt")
+	require.NoError(t, processFile("pkg/b c.go", bytes.NewReader(src), &buf, true, flagOptions()))
+	require.Equal(t, "pkg/b c.go\x00", buf.String())
+}
+
+func TestExitCode(t *testing.T) {
+	defer func(l, w bool) { *list, *write = l, w }(*list, *write)
+	defer func(c, f int32) { changedFiles, failedFiles = c, f }(changedFiles, failedFiles)
+	changedFiles, failedFiles = 0, 0
+
+	*list = true
+	var buf bytes.Buffer
+	require.NoError(t, processFile("valid.go", bytes.NewReader(testdata(t, "valid_imports.txt")), &buf, true, flagOptions()))
+	require.Equal(t, 0, exitCode())
+	require.NoError(t, processFile("foo.go", bytes.NewReader(testdata(t, "external_groups_invalid.txt")), &buf, true, flagOptions()))
+	require.Equal(t, exitNeedsFormatting, exitCode())
+
+	// the files were rewritten
+	*write = true
+	require.Equal(t, 0, exitCode())
+
+	failedFiles = 1
+	require.Equal(t, exitFileErrors, exitCode())
+}
+
+func TestIsExcluded(t *testing.T) {
+	require.False(t, isExcluded("foo.go"))
+	require.False(t, isExcluded(filepath.Join("pkg", "foo.go")))
+	require.True(t, isExcluded(filepath.Join("pkg", ".foo.go")))
+	require.True(t, isExcluded(filepath.Join("pkg", "foo.txt")))
+}
+
 func testdata(t *testing.T, str string) []byte {
 	b, err := ioutil.ReadFile("testdata/" + str)
 	require.NoError(t, err)
@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and the number of hard links of a file.
+func fileOwner(fi os.FileInfo) (uid, gid int, nlink uint64, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), uint64(st.Nlink), true
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	serveCmd = "serve"
+
+	// remoteTimeout bounds connecting to the daemon, after which go-groups formats in-process.
+	remoteTimeout = time.Second
+)
+
+// remoteDeadline bounds a request to a daemon which accepted the connection, after which go-groups
+// formats in-process.
+var remoteDeadline = 5 * time.Second
+
+// formatRequest asks for the contents of a file to be regrouped. It is sent as a single line of JSON,
+// to the serve command or to standard input with -stdin-batch.
+type formatRequest struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+	// Dir is the working directory of the client. A relative Filename, or anonymous input without one, is
+	// resolved against it to find its configuration, rather than against the directory of the daemon.
+	Dir string `json:"dir,omitempty"`
+}
+
+// formatResponse is the answer to a formatRequest, Content is empty if Error is set.
+type formatResponse struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+	Changed  bool   `json:"changed"`
+	// Skipped is the reason why the file was not processed, such as "generated".
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// serveMain runs the serve command, which regroups the files sent to a Unix socket, see formatRequest.
+// The flags are those the command is started with, and the configuration of a directory is read once.
+func serveMain(args []string) int {
+	fs := flag.NewFlagSet(serveCmd, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	socket := fs.String("socket", "", "path of the Unix socket to listen on")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] -socket path\n", os.Args[0], serveCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if err := applyConfig(fs); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		return exitBadFlags
+	}
+	if *socket == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return exitBadFlags
+	}
+
+	l, err := listenUnix(*socket)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "cannot serve: "+err.Error())
+		return exitBadFlags
+	}
+	// the formatted source is returned instead of written
+	*list, *write, *doDiff, *format = false, false, false, ""
+	cacheClasses, inProcessFormat = true, true
+	handleSignals()
+	go func() {
+		<-interruptCh
+		_ = l.Close()
+	}()
+	serve(l)
+	return 0
+}
+
+// listenUnix listens on the Unix socket at path, replacing a stale socket left by a daemon which did
+// not exit cleanly. Any other file at path is left alone.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, remoteTimeout); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serve handles the connections accepted by l until it is closed.
+func serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go handleConn(conn)
+	}
+}
+
+// handleConn answers the requests sent over conn until the client closes it.
+func handleConn(conn net.Conn) {
+	defer conn.Close()
+	_ = formatRequests(conn, conn)
+}
+
+// formatRequests answers the requests read from r, one JSON object per line, until r is exhausted. An
+// invalid request is answered with an error, after which no further requests are read.
+func formatRequests(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req formatRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			err = fmt.Errorf("invalid request: %v", err)
+			_ = enc.Encode(formatResponse{Error: err.Error()})
+			return err
+		}
+		if err := enc.Encode(formatSource(req)); err != nil {
+			return err
+		}
+	}
+}
+
+// formatSource regroups the contents of a formatRequest as go-groups does for standard input named by
+// -stdin-filename, using the filename of the request and the configuration of its directory. The contents
+// of excluded files are returned unchanged. A request without a filename is anonymous standard input, which
+// is never excluded and uses the configuration of the current directory.
+func formatSource(req formatRequest) formatResponse {
+	filename := req.Filename
+	if filename == "" {
+		filename = stdinName
+	} else if isExcluded(filename) {
+		return formatResponse{Filename: req.Filename, Content: req.Content}
+	}
+	path := filename
+	if req.Dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(req.Dir, path)
+	}
+	opts, err := optionsFor(path)
+	if err != nil {
+		return formatResponse{Filename: req.Filename, Error: err.Error()}
+	}
+	if src, _ := detectLineEndings([]byte(req.Content)); skipGenerated(filename, src, opts) {
+		reason := skippedGenerated
+		if opts.generated == generatedOnly {
+			reason = skippedNotGenerated
+		}
+		return formatResponse{Filename: req.Filename, Content: req.Content, Skipped: reason}
+	}
+	if isBuildExcluded(filename, []byte(req.Content), opts) {
+		return formatResponse{Filename: req.Filename, Content: req.Content, Skipped: skippedBuild}
+	}
+	var buf bytes.Buffer
+	if err := processFile(filename, strings.NewReader(req.Content), &buf, !*noFormat, opts); err != nil {
+		return formatResponse{Filename: req.Filename, Error: err.Error()}
+	}
+	return formatResponse{Filename: req.Filename, Content: buf.String(), Changed: buf.String() != req.Content}
+}
+
+// remoteIgnoredFlag returns the name of a grouping or policy flag given on the command line, which a daemon
+// would ignore since it regroups with the flags it was started with, or an empty string if there is none.
+func remoteIgnoredFlag() string {
+	name := ""
+	new(options).flagSet().VisitAll(func(f *flag.Flag) {
+		if name == "" && commandLineFlags[f.Name] {
+			name = f.Name
+		}
+	})
+	if name == "generated" && commandLineFlags["g"] {
+		name = "g"
+	}
+	return name
+}
+
+// errNoDaemon is returned by remoteFormat when no daemon listens on the socket.
+var errNoDaemon = errors.New("no daemon is listening")
+
+// remoteFormat sends req to the daemon listening on socket. It fails with errNoDaemon if there is none,
+// in which case the caller formats in-process.
+func remoteFormat(socket string, req formatRequest) (formatResponse, error) {
+	conn, err := net.DialTimeout("unix", socket, remoteTimeout)
+	if err != nil {
+		return formatResponse{}, fmt.Errorf("%w on %s: %v", errNoDaemon, socket, err)
+	}
+	defer conn.Close()
+	// a daemon which hangs must not block the caller, which then formats in-process
+	if err := conn.SetDeadline(time.Now().Add(remoteDeadline)); err != nil {
+		return formatResponse{}, err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return formatResponse{}, err
+	}
+	var resp formatResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return formatResponse{}, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
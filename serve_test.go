@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	defer func(c, f bool) { cacheClasses, inProcessFormat = c, f }(cacheClasses, inProcessFormat)
+	defer pathClasses.Range(func(key, _ interface{}) bool {
+		pathClasses.Delete(key)
+		return true
+	})
+	cacheClasses, inProcessFormat = true, true
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "go-groups.sock")
+
+	l, err := listenUnix(socket)
+	require.NoError(t, err)
+	go serve(l)
+	defer l.Close()
+
+	_, err = listenUnix(socket)
+	require.Error(t, err)
+
+	src := testdata(t, "external_groups_invalid.txt")
+	// the second request hits the cached classes
+	for i := 0; i < 2; i++ {
+		resp, err := remoteFormat(socket, formatRequest{Filename: "foo.go", Content: string(src)})
+		require.NoError(t, err)
+		require.Equal(t, "foo.go", resp.Filename)
+		require.True(t, resp.Changed)
+		require.Equal(t, string(testdata(t, "external_groups.txt")), resp.Content)
+	}
+
+	// anonymous standard input, as sent by go-groups -remote without -stdin-filename
+	resp, err := remoteFormat(socket, formatRequest{Content: string(src)})
+	require.NoError(t, err)
+	require.True(t, resp.Changed)
+	require.Equal(t, string(testdata(t, "external_groups.txt")), resp.Content)
+
+	resp, err = remoteFormat(socket, formatRequest{Filename: "foo.go", Content: "package foo\nimport (\n"})
+	require.Error(t, err)
+	require.Equal(t, err.Error(), resp.Error)
+	require.Contains(t, resp.Error, "foo.go:")
+}
+
+func TestRemoteFormat_HungDaemon(t *testing.T) {
+	defer func(d time.Duration) { remoteDeadline = d }(remoteDeadline)
+	remoteDeadline = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "go-groups.sock")
+	l, err := listenUnix(socket)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		// accept the connection, but never answer
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = ioutil.ReadAll(conn)
+		}
+	}()
+
+	_, err = remoteFormat(socket, formatRequest{Filename: "foo.go", Content: "package foo\n"})
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "%v", err)
+}
+
+func TestRemoteFormat_NoDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = remoteFormat(filepath.Join(dir, "go-groups.sock"), formatRequest{Filename: "foo.go"})
+	require.True(t, errors.Is(err, errNoDaemon))
+}
+
+func TestListenUnix_StaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "go-groups.sock")
+
+	// a daemon which did not exit cleanly leaves its socket behind
+	l, err := listenUnix(socket)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+
+	l, err = listenUnix(socket)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+}
+
+func TestListenUnix_NotSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "main.go")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("package main\n"), 0600))
+
+	_, err = listenUnix(filename)
+	require.EqualError(t, err, filename+" exists and is not a socket")
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "package main\n", string(content))
+}
+
+func TestFormatRequests(t *testing.T) {
+	src := string(testdata(t, "external_groups_invalid.txt"))
+	valid := string(testdata(t, "external_groups.txt"))
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	require.NoError(t, enc.Encode(formatRequest{Filename: "a.go", Content: src}))
+	require.NoError(t, enc.Encode(formatRequest{Filename: "b.go", Content: valid}))
+	require.NoError(t, enc.Encode(formatRequest{Filename: "c.go", Content: "package c\nimport (\n"}))
+	in.WriteString("{\"filename\": \n")
+
+	var out bytes.Buffer
+	require.Error(t, formatRequests(&in, &out))
+
+	var responses []formatResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp formatResponse
+		require.NoError(t, dec.Decode(&resp))
+		responses = append(responses, resp)
+	}
+	require.Len(t, responses, 4)
+	require.Equal(t, formatResponse{Filename: "a.go", Content: valid, Changed: true}, responses[0])
+	require.Equal(t, formatResponse{Filename: "b.go", Content: valid}, responses[1])
+	require.Equal(t, "c.go", responses[2].Filename)
+	require.Contains(t, responses[2].Error, "c.go")
+	require.Contains(t, responses[3].Error, "invalid request")
+}
+
+func TestFormatRequests_Config(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+		config := []byte("local=example.com/" + name + "\n")
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, configName), config, 0644))
+	}
+
+	// the files do not need to exist, the configuration is found from the directory of each filename
+	src := "package x\n\nimport (\n\t\"example.com/a/x\"\n\t\"example.com/b/y\"\n)\n"
+	var in, out bytes.Buffer
+	enc := json.NewEncoder(&in)
+	require.NoError(t, enc.Encode(formatRequest{Filename: filepath.Join(dir, "a", "x.go"), Content: src}))
+	require.NoError(t, enc.Encode(formatRequest{Filename: filepath.Join(dir, "b", "pkg", "x.go"), Content: src}))
+	require.NoError(t, formatRequests(&in, &out))
+
+	var responses []formatResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp formatResponse
+		require.NoError(t, dec.Decode(&resp))
+		responses = append(responses, resp)
+	}
+	require.Len(t, responses, 2)
+	require.Empty(t, responses[0].Error)
+	require.Equal(t, "package x\n\nimport (\n\t\"example.com/b/y\"\n\n\t\"example.com/a/x\"\n)\n", responses[0].Content)
+	require.Empty(t, responses[1].Error)
+	require.Equal(t, "package x\n\nimport (\n\t\"example.com/a/x\"\n\n\t\"example.com/b/y\"\n)\n", responses[1].Content)
+}
+
+func TestFormatSource_Dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, configName), []byte("local=example.com/a\n"), 0644))
+
+	// the configuration is found from the working directory of the client, not the one of the daemon
+	src := "package x\n\nimport (\n\t\"example.com/a/x\"\n\t\"example.com/b/y\"\n)\n"
+	expected := "package x\n\nimport (\n\t\"example.com/b/y\"\n\n\t\"example.com/a/x\"\n)\n"
+	resp := formatSource(formatRequest{Filename: "x.go", Content: src, Dir: dir})
+	require.Equal(t, formatResponse{Filename: "x.go", Content: expected, Changed: true}, resp)
+	resp = formatSource(formatRequest{Content: src, Dir: dir})
+	require.Equal(t, formatResponse{Content: expected, Changed: true}, resp)
+	resp = formatSource(formatRequest{Filename: "x.go", Content: src})
+	require.Equal(t, "package x\n\nimport (\n\t\"example.com/a/x\"\n\n\t\"example.com/b/y\"\n)\n", resp.Content)
+}
+
+func TestFormatSource_GeneratedOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, configName), []byte("generated=only\n"), 0644))
+
+	src := string(testdata(t, "external_groups_invalid.txt"))
+	resp := formatSource(formatRequest{Filename: "foo.go", Content: src, Dir: dir})
+	require.Equal(t, formatResponse{Filename: "foo.go", Content: src, Skipped: skippedNotGenerated}, resp)
+
+	resp = formatSource(formatRequest{Filename: "foo.go", Content: string(testdata(t, "code_generated.txt")), Dir: dir})
+	require.Empty(t, resp.Error)
+	require.Empty(t, resp.Skipped)
+}
+
+func TestRemoteIgnoredFlag(t *testing.T) {
+	defer func() { commandLineFlags = make(map[string]bool) }()
+	commandLineFlags = map[string]bool{"remote": true, "stdin-filename": true}
+	require.Empty(t, remoteIgnoredFlag())
+	commandLineFlags["local"] = true
+	require.Equal(t, "local", remoteIgnoredFlag())
+	commandLineFlags = map[string]bool{"g": true, "generated": true}
+	require.Equal(t, "g", remoteIgnoredFlag())
+}
+
+func TestFormatSource_Excluded(t *testing.T) {
+	src := string(testdata(t, "external_groups_invalid.txt"))
+	resp := formatSource(formatRequest{Filename: filepath.Join("pkg", ".foo.go"), Content: src})
+	require.Equal(t, formatResponse{Filename: filepath.Join("pkg", ".foo.go"), Content: src}, resp)
+}
+
+func TestFormatSource_Generated(t *testing.T) {
+	src := string(testdata(t, "code_generated.txt"))
+	resp := formatSource(formatRequest{Filename: "foo.go", Content: src})
+	require.Equal(t, formatResponse{Filename: "foo.go", Content: src, Skipped: skippedGenerated}, resp)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// exitInterrupted is the conventional exit code of a process stopped by SIGINT.
+const exitInterrupted = 130
+
+var errInterrupted = errors.New("interrupted")
+
+var (
+	interrupted    int32
+	processedFiles int32
+	// interruptCh is closed once interrupted is set, for the commands which wait for events.
+	interruptCh = make(chan struct{})
+
+	// pendingFiles are the temporary files of writes in progress. A temporary file which replaces
+	// its target is removed on rollback, a backup of its target is restored over the target.
+	pendingMu    sync.Mutex
+	pendingFiles = make(map[string]pendingFile)
+)
+
+type pendingFile struct {
+	target string
+	backup bool
+}
+
+// handleSignals stops go-groups from processing further files once SIGINT or SIGTERM is received.
+// The file being processed is finished, so that a write is never interrupted halfway. A second signal
+// rolls back the writes in progress and exits immediately.
+func handleSignals() {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		atomic.StoreInt32(&interrupted, 1)
+		close(interruptCh)
+		<-c
+		rollbackPendingFiles()
+		_, _ = fmt.Fprintln(os.Stderr, "go-groups: interrupted, rolled back writes in progress")
+		os.Exit(exitInterrupted)
+	}()
+}
+
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) != 0
+}
+
+// trackPendingFile registers a temporary file created while writing target, see pendingFiles.
+func trackPendingFile(name, target string, backup bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingFiles[name] = pendingFile{target: target, backup: backup}
+}
+
+// untrackPendingFile is called once the temporary file has been renamed or removed.
+func untrackPendingFile(name string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	delete(pendingFiles, name)
+}
+
+func rollbackPendingFiles() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for name, pending := range pendingFiles {
+		if pending.backup {
+			_ = os.Rename(name, pending.target)
+		} else {
+			_ = os.Remove(name)
+		}
+		delete(pendingFiles, name)
+	}
+}
+
+// reportInterrupted prints a summary of an interrupted run. remaining are the paths which were not or,
+// for the first one if partial is set, only partially processed.
+func reportInterrupted(remaining []string, partial bool) {
+	_, _ = fmt.Fprintf(os.Stderr, "go-groups: interrupted after processing %d files\n", atomic.LoadInt32(&processedFiles))
+	if len(remaining) == 0 {
+		return
+	}
+	if partial {
+		_, _ = fmt.Fprintln(os.Stderr, "partially processed: "+remaining[0])
+		remaining = remaining[1:]
+	}
+	if len(remaining) > 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "not processed: "+strings.Join(remaining, " "))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackPendingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "foo.go")
+	backup := filepath.Join(dir, "foo.go.123")
+	temp := filepath.Join(dir, "bar.go.456")
+	require.NoError(t, ioutil.WriteFile(target, []byte("half writ"), 0644))
+	require.NoError(t, ioutil.WriteFile(backup, []byte("original"), 0644))
+	require.NoError(t, ioutil.WriteFile(temp, []byte("new"), 0644))
+	trackPendingFile(backup, target, true)
+	trackPendingFile(temp, filepath.Join(dir, "bar.go"), false)
+
+	rollbackPendingFiles()
+
+	content, err := ioutil.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "original", string(content))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Empty(t, pendingFiles)
+}
+
+func TestWalkDir_Interrupted(t *testing.T) {
+	defer atomic.StoreInt32(&interrupted, 0)
+	atomic.StoreInt32(&interrupted, 1)
+
+	err := walkDir("testdata")
+	require.True(t, errors.Is(err, errInterrupted))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// verifyRewrite parses the original and the rewritten source and checks that regrouping only moved imports
+// and comments around: both must import the same (alias, path) pairs, where duplicates may only be removed,
+// contain every comment as many times and be identical outside of their import declarations. Header comments
+// generated by go-groups are ignored, and with -drop-comments comments may be removed but never added.
+func verifyRewrite(original, rewritten []byte, opts *options) error {
+	before, err := parseForVerification(original, opts)
+	if err != nil {
+		return fmt.Errorf("cannot parse original source: %v", err)
+	}
+	after, err := parseForVerification(rewritten, opts)
+	if err != nil {
+		return fmt.Errorf("cannot parse rewritten source: %v", err)
+	}
+
+	if missing := absent(before.imports, after.imports); len(missing) > 0 {
+		return fmt.Errorf("imports would be removed: %s", strings.Join(missing, ", "))
+	}
+	if added := difference(after.imports, before.imports); len(added) > 0 {
+		return fmt.Errorf("imports would be added: %s", strings.Join(added, ", "))
+	}
+	if missing := difference(before.comments, after.comments); len(missing) > 0 && !opts.dropComments {
+		return fmt.Errorf("comments would be removed: %s", strings.Join(missing, ", "))
+	}
+	if added := difference(after.comments, before.comments); len(added) > 0 {
+		return fmt.Errorf("comments would be added: %s", strings.Join(added, ", "))
+	}
+	if before.code != after.code {
+		return fmt.Errorf("code outside of import declarations would change")
+	}
+	return nil
+}
+
+// verifiedSource is the information about a source file which regrouping must not change.
+type verifiedSource struct {
+	imports  map[string]int
+	comments map[string]int
+	code     string
+}
+
+func parseForVerification(src []byte, opts *options) (verifiedSource, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return verifiedSource{}, err
+	}
+
+	source := verifiedSource{
+		imports:  make(map[string]int, len(f.Imports)),
+		comments: make(map[string]int, len(f.Comments)),
+	}
+	for _, spec := range f.Imports {
+		imp := spec.Path.Value
+		if spec.Name != nil {
+			imp = spec.Name.Name + " " + imp
+		}
+		source.imports[imp]++
+	}
+
+	headerPatterns := groupHeaders(opts)
+	for _, group := range f.Comments {
+		for _, comment := range group.List {
+			if !isGroupHeader(headerPatterns, comment.Text) {
+				source.comments[comment.Text]++
+			}
+		}
+	}
+
+	// declarations are printed without their comments, which are compared separately
+	code := bytes.NewBufferString("package " + f.Name.Name + "\n")
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		if err := printer.Fprint(code, fset, decl); err != nil {
+			return verifiedSource{}, err
+		}
+		code.WriteString("\n")
+	}
+	source.code = code.String()
+	return source, nil
+}
+
+// difference returns the sorted elements of a which occur more often in a than in b.
+func difference(a, b map[string]int) []string {
+	diff := make([]string, 0)
+	for s, n := range a {
+		if n > b[s] {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// absent returns the sorted elements of a which do not occur in b at all.
+func absent(a, b map[string]int) []string {
+	diff := make([]string, 0)
+	for s := range a {
+		if b[s] == 0 {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
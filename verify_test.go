@@ -0,0 +1,191 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyRewrite(t *testing.T) {
+	original := `package main
+
+import (
+	"os"
+	// fmt is used for printing
+	"fmt"
+)
+
+func main() {
+	fmt.Println(os.Args) // print the arguments
+}
+`
+	type testcase struct {
+		Description string
+		Rewritten   string
+		Error       string
+	}
+	testcases := []testcase{
+		{
+			Description: "regrouped imports should pass verification",
+			Rewritten: `package main
+
+import (
+	// fmt is used for printing
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(os.Args) // print the arguments
+}
+`,
+		},
+		{
+			Description: "a dropped import should fail verification",
+			Rewritten: `package main
+
+import (
+	// fmt is used for printing
+	"fmt"
+)
+
+func main() {
+	fmt.Println(os.Args) // print the arguments
+}
+`,
+			Error: `imports would be removed: "os"`,
+		},
+		{
+			Description: "a renamed import should fail verification",
+			Rewritten: `package main
+
+import (
+	// fmt is used for printing
+	"fmt"
+	o "os"
+)
+
+func main() {
+	fmt.Println(os.Args) // print the arguments
+}
+`,
+			Error: `imports would be removed: "os"`,
+		},
+		{
+			Description: "a dropped comment should fail verification",
+			Rewritten: `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(os.Args) // print the arguments
+}
+`,
+			Error: "comments would be removed: // fmt is used for printing",
+		},
+		{
+			Description: "changed code should fail verification",
+			Rewritten: `package main
+
+import (
+	// fmt is used for printing
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println(os.Args[1:]) // print the arguments
+}
+`,
+			Error: "code outside of import declarations would change",
+		},
+		{
+			Description: "unparsable output should fail verification",
+			Rewritten:   "package main\n\nimport (\n",
+			Error:       "cannot parse rewritten source: ",
+		},
+	}
+	for _, testcase := range testcases {
+		err := verifyRewrite([]byte(original), []byte(testcase.Rewritten), flagOptions())
+		if testcase.Error == "" {
+			require.NoError(t, err, testcase.Description)
+		} else {
+			require.Error(t, err, testcase.Description)
+			require.Contains(t, err.Error(), testcase.Error, testcase.Description)
+		}
+	}
+}
+
+func TestVerifyRewrite_Duplicates(t *testing.T) {
+	original := `package main
+
+import (
+	"fmt" // printing
+	"os"
+	"fmt" // printing
+)
+`
+	type testcase struct {
+		Description string
+		Rewritten   string
+		Error       string
+	}
+	testcases := []testcase{
+		{
+			Description: "a merged duplicate import keeping both comments should pass verification",
+			Rewritten: `package main
+
+import (
+	// printing
+	"fmt" // printing
+	"os"
+)
+`,
+		},
+		{
+			Description: "a merged duplicate import dropping an identical comment should fail verification",
+			Rewritten: `package main
+
+import (
+	"fmt" // printing
+	"os"
+)
+`,
+			Error: "comments would be removed: // printing",
+		},
+		{
+			Description: "a repeated import should fail verification",
+			Rewritten: `package main
+
+import (
+	"fmt" // printing
+	"fmt" // printing
+	"os"
+	"os"
+)
+`,
+			Error: `imports would be added: "os"`,
+		},
+	}
+	for _, testcase := range testcases {
+		err := verifyRewrite([]byte(original), []byte(testcase.Rewritten), flagOptions())
+		if testcase.Error == "" {
+			require.NoError(t, err, testcase.Description)
+		} else {
+			require.Error(t, err, testcase.Description)
+			require.Contains(t, err.Error(), testcase.Error, testcase.Description)
+		}
+	}
+}
+
+func TestVerifyRewrite_Headers(t *testing.T) {
+	defer func(h bool) { *headers = h }(*headers)
+	*headers = true
+
+	original := "package main\n\nimport (\n\t\"fmt\"\n)\n"
+	rewritten := "package main\n\nimport (\n\t// Standard library\n\t\"fmt\"\n)\n"
+	require.NoError(t, verifyRewrite([]byte(original), []byte(rewritten), flagOptions()))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/scanner"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const watchCmd = "watch"
+
+// fileWatcher reports the paths of files which may have changed below the watched paths.
+type fileWatcher interface {
+	changes() <-chan string
+	close() error
+}
+
+// watchMain runs the watch command, which rewrites Go files as if by go-groups -w whenever they change.
+func watchMain(args []string) int {
+	fs := flag.NewFlagSet(watchCmd, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	debounce := fs.Duration("debounce", 200*time.Millisecond, "wait until a file has not changed for this long before regrouping it")
+	poll := fs.Duration("poll", 0, "poll for changes at this interval instead of using file system notifications")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s [flags] [path ...]\n", os.Args[0], watchCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if err := applyConfig(fs); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid configuration: "+err.Error())
+		return exitBadFlags
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "cannot watch '"+path+"': "+err.Error())
+			return exitBadFlags
+		}
+	}
+
+	var w fileWatcher
+	var err error
+	if *poll == 0 {
+		if w, err = newNotifyWatcher(paths); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "go-groups: "+err.Error()+", polling for changes instead")
+			*poll = time.Second
+		}
+	}
+	if *poll != 0 {
+		w = newPollWatcher(paths, *poll)
+	}
+	defer w.close()
+
+	// the rewritten files are listed as they are written
+	*write, *list = true, true
+	handleSignals()
+	watchLoop(w, *debounce, os.Stdout, interruptCh)
+	return 0
+}
+
+// watchLoop regroups the changed files reported by w until done is closed. A file is processed once it
+// has not changed for the debounce duration, so that files which are still being written are skipped.
+func watchLoop(w fileWatcher, debounce time.Duration, out io.Writer, done <-chan struct{}) {
+	pending := make(map[string]os.FileInfo)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case path, ok := <-w.changes():
+			if !ok {
+				return
+			}
+			fi, err := os.Stat(path)
+			if err != nil || !isGoFile(fi) {
+				continue
+			}
+			pending[path] = fi
+			timer.Reset(debounce)
+		case <-timer.C:
+			for path, before := range pending {
+				delete(pending, path)
+				fi, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if fi.Size() != before.Size() || !fi.ModTime().Equal(before.ModTime()) {
+					// still being written, wait for it to settle
+					pending[path] = fi
+					timer.Reset(debounce)
+					continue
+				}
+				err = processPath(path, out)
+				if err != nil && !os.IsNotExist(err) {
+					scanner.PrintError(os.Stderr, err)
+				}
+			}
+		}
+	}
+}
+
+// isWatchedDir reports whether the directory at path should be watched. Hidden directories such as .git,
+// except for the watched paths themselves, and the -backup-dir are skipped.
+func isWatchedDir(path string, root bool) bool {
+	name := filepath.Base(path)
+	return !isBackupDir(path) && (root || !strings.HasPrefix(name, "."))
+}
+
+// pollWatcher is the fileWatcher used where file system notifications are not available. It scans the
+// watched paths at a fixed interval and reports the files whose size or modification time changed.
+type pollWatcher struct {
+	paths []string
+	files map[string]os.FileInfo
+	c     chan string
+	done  chan struct{}
+}
+
+func newPollWatcher(paths []string, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		paths: paths,
+		files: make(map[string]os.FileInfo),
+		c:     make(chan string),
+		done:  make(chan struct{}),
+	}
+	w.scan(false)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				if !w.scan(true) {
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) changes() <-chan string {
+	return w.c
+}
+
+func (w *pollWatcher) close() error {
+	close(w.done)
+	return nil
+}
+
+// scan records the state of every Go file and, if report is set, sends the files which changed since the
+// previous scan. It returns false once the watcher is closed.
+func (w *pollWatcher) scan(report bool) bool {
+	changed := make([]string, 0)
+	for _, root := range w.paths {
+		_ = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if fi.IsDir() && !isWatchedDir(path, path == root) {
+				return filepath.SkipDir
+			}
+			if !isGoFile(fi) {
+				return nil
+			}
+			if before, ok := w.files[path]; !ok || fi.Size() != before.Size() || !fi.ModTime().Equal(before.ModTime()) {
+				changed = append(changed, path)
+			}
+			w.files[path] = fi
+			return nil
+		})
+	}
+	if !report {
+		return true
+	}
+	for _, path := range changed {
+		select {
+		case w.c <- path:
+		case <-w.done:
+			return false
+		}
+	}
+	return true
+}
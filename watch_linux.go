@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// notifyWatcher is the inotify based fileWatcher. Every directory below the watched paths is watched,
+// including the directories created while watching.
+type notifyWatcher struct {
+	fd   int
+	f    *os.File
+	c    chan string
+	done chan struct{}
+
+	mu      sync.Mutex
+	watches map[int]string
+}
+
+func newNotifyWatcher(paths []string) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// the non-blocking descriptor is handled by the runtime poller, so that close interrupts read
+	w := &notifyWatcher{
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		c:       make(chan string),
+		done:    make(chan struct{}),
+		watches: make(map[int]string),
+	}
+	for _, path := range paths {
+		if err := w.addTree(path, true, false); err != nil {
+			_ = w.f.Close()
+			return nil, err
+		}
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *notifyWatcher) changes() <-chan string {
+	return w.c
+}
+
+func (w *notifyWatcher) close() error {
+	close(w.done)
+	return w.f.Close()
+}
+
+// send reports path as changed, it returns false once the watcher is closed.
+func (w *notifyWatcher) send(path string) bool {
+	select {
+	case w.c <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// addTree watches the directory at root and the directories below it. If report is set, the Go files
+// found are reported as changed, since they may have been written before the watch was added.
+func (w *notifyWatcher) addTree(root string, isRoot, report bool) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// the directory may have been removed in the meantime
+			if isRoot && path == root {
+				return err
+			}
+			return nil
+		}
+		switch {
+		case fi.IsDir() && !isWatchedDir(path, isRoot && path == root):
+			return filepath.SkipDir
+		case fi.IsDir() || path == root:
+			wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+			if err != nil {
+				return os.NewSyscallError("inotify_add_watch "+path, err)
+			}
+			w.mu.Lock()
+			w.watches[wd] = path
+			w.mu.Unlock()
+		case report && isGoFile(fi):
+			if !w.send(path) {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+}
+
+func (w *notifyWatcher) read() {
+	defer close(w.c)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			w.mu.Lock()
+			path, ok := w.watches[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				// the watched directory was removed
+				delete(w.watches, int(event.Wd))
+				ok = false
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if name != "" {
+				path = filepath.Join(path, name)
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					_ = w.addTree(path, false, true)
+				}
+				continue
+			}
+			if !w.send(path) {
+				return
+			}
+		}
+	}
+}
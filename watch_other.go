@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// newNotifyWatcher fails on platforms without inotify, where the watch command polls for changes.
+func newNotifyWatcher(paths []string) (fileWatcher, error) {
+	return nil, errors.New("file system notifications are not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWatcher chan string
+
+func (w fakeWatcher) changes() <-chan string { return w }
+
+func (w fakeWatcher) close() error { return nil }
+
+func TestWatchLoop(t *testing.T) {
+	defer func(w, l bool) { *write, *list = w, l }(*write, *list)
+	*write, *list = true, true
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "foo.go")
+	require.NoError(t, ioutil.WriteFile(filename, testdata(t, "external_groups_invalid.txt"), 0644))
+	ignored := filepath.Join(dir, "foo.txt")
+	require.NoError(t, ioutil.WriteFile(ignored, testdata(t, "external_groups_invalid.txt"), 0644))
+
+	w := make(fakeWatcher)
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		w <- ignored
+		w <- filename
+		time.Sleep(100 * time.Millisecond)
+		close(done)
+	}()
+	watchLoop(w, 10*time.Millisecond, &out, done)
+	require.Equal(t, filename+"\n", out.String())
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, string(testdata(t, "external_groups.txt")), string(content))
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0755))
+
+	w := newPollWatcher([]string{dir}, 10*time.Millisecond)
+	defer w.close()
+	testWatcher(t, w, dir)
+}
+
+func TestNotifyWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0755))
+
+	w, err := newNotifyWatcher([]string{dir})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer w.close()
+	testWatcher(t, w, dir)
+
+	// directories created while watching are watched as well
+	sub := filepath.Join(dir, "sub")
+	require.NoError(t, os.MkdirAll(filepath.Join(sub, ".git"), 0755))
+	testWatcher(t, w, sub)
+}
+
+// testWatcher checks that w reports a Go file written to dir, but not a file written to dir/.git.
+func testWatcher(t *testing.T, w fileWatcher, dir string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".git", "foo.go"), []byte("package foo\n"), 0644))
+	filename := filepath.Join(dir, "foo.go")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("package foo\n"), 0644))
+	for {
+		select {
+		case path := <-w.changes():
+			require.NotContains(t, path, ".git")
+			if path == filename {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change reported for " + filename)
+		}
+	}
+}
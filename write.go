@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errModified is returned when a file changed between reading and rewriting it.
+var errModified = errors.New("file was modified while it was being processed, skipping it")
+
+// fileSnapshot records the state of a file when it was read.
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+	sum     [sha256.Size]byte
+}
+
+func takeSnapshot(fi os.FileInfo, content []byte) fileSnapshot {
+	return fileSnapshot{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		sum:     sha256.Sum256(content),
+	}
+}
+
+// checkUnmodified fails with errModified if filename no longer matches the snapshot. It is called right
+// before a rewrite is committed, so that changes made by an editor or another tool in the meantime are
+// never overwritten.
+func (s fileSnapshot) checkUnmodified(filename string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.Size() != s.size || !fi.ModTime().Equal(s.modTime) {
+		return fmt.Errorf("%s: %w", filename, errModified)
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(content) != s.sum {
+		return fmt.Errorf("%s: %w", filename, errModified)
+	}
+	return nil
+}
+
+// writeFile replaces the contents of filename, whose original contents are src, with res.
+// The new contents are written to a temporary file in the same directory, synced and renamed over the
+// original, so that a crash never leaves a truncated file behind. The permissions and owner of the
+// original are preserved, as well as its modification time with -keep-mtime. Symlinks, files with
+// several hard links and files whose owner cannot be preserved are rewritten in place instead,
+// since replacing them would break the link or change the owner.
+// If the file no longer matches snapshot when the rewrite is about to be committed, it is left untouched.
+func writeFile(filename string, src, res []byte, snapshot fileSnapshot) error {
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	uid, gid, nlink, ownerKnown := fileOwner(fi)
+	if *inPlace || fi.Mode()&os.ModeSymlink != 0 || nlink > 1 {
+		return writeInPlace(filename, src, res, snapshot)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	tmpname := f.Name()
+	trackPendingFile(tmpname, filename, false)
+	defer untrackPendingFile(tmpname)
+	if err := writeSynced(f, res, fi.Mode().Perm()); err != nil {
+		_ = os.Remove(tmpname)
+		return err
+	}
+	if ownerKnown && (uid != os.Getuid() || gid != os.Getgid()) {
+		if err := os.Chown(tmpname, uid, gid); err != nil {
+			_ = os.Remove(tmpname)
+			return writeInPlace(filename, src, res, snapshot)
+		}
+	}
+	if *keepMtime {
+		if err := os.Chtimes(tmpname, time.Now(), fi.ModTime()); err != nil {
+			_ = os.Remove(tmpname)
+			return err
+		}
+	}
+	if err := snapshot.checkUnmodified(filename); err != nil {
+		_ = os.Remove(tmpname)
+		return err
+	}
+	if err := os.Rename(tmpname, filename); err != nil {
+		_ = os.Remove(tmpname)
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// writeSynced writes data to f with permissions perm, syncs and closes it.
+func writeSynced(f *os.File, data []byte, perm os.FileMode) error {
+	var err error
+	if chmodSupported {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// writeInPlace overwrites filename with res, keeping a temporary backup of its original contents src
+// which is restored if writing fails.
+func writeInPlace(filename string, src, res []byte, snapshot fileSnapshot) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	perm := fi.Mode().Perm()
+	if err := snapshot.checkUnmodified(filename); err != nil {
+		return err
+	}
+
+	// make a temporary backup before overwriting original
+	bakname, err := backupFile(filename+".", src, perm)
+	if err != nil {
+		return err
+	}
+	trackPendingFile(bakname, filename, true)
+	defer untrackPendingFile(bakname)
+	err = ioutil.WriteFile(filename, res, perm)
+	if err != nil {
+		_ = os.Rename(bakname, filename)
+		return err
+	}
+	if *keepMtime {
+		if err := os.Chtimes(filename, time.Now(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	return os.Remove(bakname)
+}
+
+// syncDir flushes the directory entry of a renamed file to disk where the platform supports it.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	defer func(k bool) { *keepMtime = k }(*keepMtime)
+	*keepMtime = true
+
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "foo.go")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("old"), 0600))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(filename, mtime, mtime))
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.NoError(t, writeFile(filename, []byte("old"), []byte("new"), takeSnapshot(fi, []byte("old"))))
+
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+	fi, err = os.Stat(filename)
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(mtime))
+	if chmodSupported {
+		require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestWriteFile_Links(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skip test on %s: links are required", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "foo.go")
+	hardlink := filepath.Join(dir, "hardlink.go")
+	symlink := filepath.Join(dir, "symlink.go")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("old"), 0644))
+	require.NoError(t, os.Link(filename, hardlink))
+	require.NoError(t, os.Symlink(filename, symlink))
+
+	// rewriting the hard link in place is visible through the original name
+	require.NoError(t, writeFile(hardlink, []byte("old"), []byte("new"), snapshot(t, hardlink)))
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+
+	// rewriting the symlink keeps it a symlink
+	require.NoError(t, writeFile(symlink, []byte("new"), []byte("newer"), snapshot(t, symlink)))
+	fi, err := os.Lstat(symlink)
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&os.ModeSymlink)
+	content, err = ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "newer", string(content))
+}
+
+func TestWriteFile_ConcurrentModification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-groups")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "foo.go")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("old"), 0644))
+	before := snapshot(t, filename)
+
+	// same size, so only the content hash can tell the difference if the mtime resolution is coarse
+	require.NoError(t, ioutil.WriteFile(filename, []byte("odd"), 0644))
+
+	err = writeFile(filename, []byte("old"), []byte("new"), before)
+	require.True(t, errors.Is(err, errModified))
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "odd", string(content))
+
+	*inPlace = true
+	defer func() { *inPlace = false }()
+	err = writeFile(filename, []byte("old"), []byte("new"), before)
+	require.True(t, errors.Is(err, errModified))
+}
+
+func snapshot(t *testing.T, filename string) fileSnapshot {
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	return takeSnapshot(fi, content)
+}